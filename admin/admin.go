@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/jamf"
+	"github.com/cosmouser/mudwork/logging"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// Handler serves the admin API under /admin/. Every request must carry
+// AdminToken as a bearer token, and the API answers 404 to everything
+// while no AdminToken is configured.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/sources", handleSources)
+	mux.HandleFunc("/admin/unresolved", handleUnresolved)
+	mux.HandleFunc("/admin/loglevel", handleLogLevel)
+	return authorize(mux)
+}
+
+// authorize rejects requests without the AdminToken
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.C.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.C.AdminToken)) != 1 {
+			log.WithFields(log.Fields{
+				"path":    r.URL.Path,
+				"xrealip": r.Header.Get("X-Real-IP"),
+			}).Warn("Rejected admin API request")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleSources lists the devices that justify each user's license.
+// ?user=jdoe limits the list to one user.
+func handleSources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var sources []data.LicenseSource
+	if user := r.URL.Query().Get("user"); user != "" {
+		sources = data.GetLicenseSources(user)
+	} else {
+		sources = data.GetAllLicenseSources()
+	}
+	writeJSON(w, sources)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Unable to write admin API response")
+	}
+}
+
+// handleUnresolved lists the usernames from the JSS that the last sync
+// couldn't map to a uid
+func handleUnresolved(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, jamf.Rejected())
+}
+
+// LogLevel is the body of /admin/loglevel
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// handleLogLevel reports the level logged, and changes it for a PUT
+// such as {"level": "debug"} until Mudwork restarts
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var l LogLevel
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := logging.SetLevel(l.Level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, LogLevel{Level: logging.Level()})
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	saved := config.C
+	defer func() { config.C = saved }()
+	err := data.ReplaceLicenseSources([]data.LicenseSource{
+		{UniqueID: "jdoe", Kind: "computer", DeviceID: 10, DeviceName: "lab-01", SerialNumber: "C02A", Source: "computer group Lab"},
+		{UniqueID: "asmith", Kind: "mobile", DeviceID: 40, DeviceName: "ipad-40", Source: "mobile device group iPad Cart"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Handler()
+
+	config.C.AdminToken = ""
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/sources", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got %d with no AdminToken, wanted 404\n", rec.Code)
+	}
+
+	config.C.AdminToken = "s3cret"
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin/sources", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got %d with the wrong token, wanted 401\n", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/admin/sources?user=jdoe", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	h.ServeHTTP(rec, req)
+	var sources []data.LicenseSource
+	if err := json.Unmarshal(rec.Body.Bytes(), &sources); err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].SerialNumber != "C02A" {
+		t.Errorf("got %+v, wanted jdoe's lab computer\n", sources)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	saved := config.C
+	defer func() { config.C = saved }()
+	config.C.AdminToken = "s3cret"
+	defer logging.SetLevel(logging.Level())
+	h := Handler()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/admin/loglevel", strings.NewReader(`{"level": "debug"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	h.ServeHTTP(rec, req)
+	var l LogLevel
+	if err := json.Unmarshal(rec.Body.Bytes(), &l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Level != "debug" || logging.Level() != "debug" {
+		t.Errorf("got %+v and level %s, wanted debug\n", l, logging.Level())
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", "/admin/loglevel", strings.NewReader(`{"level": "chatty"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || logging.Level() != "debug" {
+		t.Errorf("got %d and level %s for an unknown level, wanted 400 and debug\n", rec.Code, logging.Level())
+	}
+}
@@ -1,29 +1,207 @@
 package config
 
 import (
-        "flag"
-        "github.com/BurntSushi/toml"
-        log "github.com/sirupsen/logrus"
+	"flag"
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
 )
 
 type Config struct {
-        JssUrl        string
-        JssIP         string
-        ApiUser       string
-        ApiPass       string
-        AdvSearchID   int
-        CirrupUser    string
-        DbPath        string
-        LdapFirstName string
-        LdapLastName  string
-        LdapUrl       string
-        LdapPort      int
-        LdapBase      string
-        AdobeGroup    string
-        Server        map[string]string
-        Enterprise    map[string]string
+	JssUrl               string
+	JssIP                string
+	ApiUser              string
+	ApiPass              string
+	JamfAuth             string
+	ApiClientID          string
+	ApiClientSecret      string
+	AdvSearchID          int
+	ComputerGroupIDs     []int
+	MobileSearchID       int
+	MobileDeviceGroupIDs []int
+	ExtensionAttributeID int
+	RemovalGracePeriod   int
+	CirrupUser           string
+	DbPath               string
+	AdminToken           string
+	LdapFirstName        string
+	LdapLastName         string
+	LdapEmail            string
+	LdapAttributes       LdapAttributesConfig
+	DefaultCountry       string
+	LdapUrl              string
+	LdapPort             int
+	LdapBase             string
+	LdapStartTLS         bool
+	LdapCACert           string
+	LdapBindDN           string
+	LdapBindPassword     string
+	LdapBindPasswordFile string
+	LdapTimeout          int
+	LdapPoolSize         int
+	LdapCacheTTL         *int
+	Directories          []string
+	OverrideFile         string
+	ActiveDirectory      ActiveDirectoryConfig
+	AdobeGroup           string
+	AccountType          string
+	AccountTypes         map[string]string
+	Server               map[string]string
+	Enterprise           map[string]string
+	Retry                RetryConfig
+	RateLimit            RateLimitConfig
+	Lifecycle            LifecycleConfig
+	Usernames            UsernameConfig
+	Eligibility          EligibilityConfig
+	Quota                QuotaConfig
+	Tracing              TracingConfig
+	Logging              LoggingConfig
 }
 
+// RetryConfig controls how requests to Adobe are retried. Delays are
+// in seconds. Zero values fall back to the defaults in umapi.
+type RetryConfig struct {
+	MaxAttempts int
+	BaseDelay   int
+	MaxDelay    int
+}
+
+// RateLimitConfig is the request budget mudwork allows itself against
+// the User Management API before Adobe's response headers refine it.
+// Window is in seconds.
+type RateLimitConfig struct {
+	Requests int
+	Window   int
+}
+
+// LifecycleConfig is the policy for accounts beyond adding and removing
+// the AdobeGroup. Users who have gone RemoveAfterDays without a license
+// are removed from the org (or, with RemoveFrom = "domain", deleted from
+// the domain) and DeleteAccount also deletes their account. SyncNames
+// pushes first and last name changes from LDAP to Adobe. Interval is
+// the number of hours between runs.
+type LifecycleConfig struct {
+	RemoveAfterDays int
+	RemoveFrom      string
+	DeleteAccount   bool
+	SyncNames       bool
+	Interval        int
+}
+
+// LdapAttributesConfig maps Person fields to directory attributes.
+// Each field lists attributes in order of preference so that, for
+// example, a preferred email alias can fall back to mail.
+type LdapAttributesConfig struct {
+	Email     []string
+	FirstName []string
+	LastName  []string
+	Country   []string
+}
+
+// ActiveDirectoryConfig describes an Active Directory domain to search
+// for users. LookupBy is "sAMAccountName" (the default) or
+// "userPrincipalName", in which case uids are looked up as
+// uid@UpnSuffix. Attributes defaults to mail, givenName, sn and c.
+// CacheTTL, like LdapCacheTTL, is 300 seconds when unset and 0 disables
+// the cache.
+type ActiveDirectoryConfig struct {
+	Url              string
+	Port             int
+	Base             string
+	StartTLS         bool
+	CACert           string
+	BindDN           string
+	BindPassword     string
+	BindPasswordFile string
+	Timeout          int
+	PoolSize         int
+	CacheTTL         *int
+	LookupBy         string
+	UpnSuffix        string
+	Attributes       LdapAttributesConfig
+}
+
+// UsernameConfig is the policy usernames from the JSS must satisfy
+// before they are queued. Pattern is a regular expression, AllowedChars
+// lists every permitted character and Lowercase folds names to lower
+// case before they are checked. Empty values are not enforced, except
+// that MinLength defaults to 2.
+//
+// Before a name is checked it is mapped to a uid: StripNetbios drops a
+// DOMAIN\ prefix, StripDomains lists the @domain suffixes to drop,
+// Aliases maps names to uids and ResolveEmail looks up any name still
+// shaped like an email address in the directory.
+type UsernameConfig struct {
+	Pattern      string
+	AllowedChars string
+	MinLength    int
+	MaxLength    int
+	Lowercase    bool
+	StripNetbios bool
+	StripDomains []string
+	Aliases      map[string]string
+	ResolveEmail bool
+}
+
+// QuotaConfig keeps adds within the AdobeGroup's license quota. Adds
+// that would take the group past Threshold percent of its quota are
+// held until seats free up (Action = "hold", the default) or rejected
+// (Action = "reject"). Priority decides who gets the seats left:
+// "queued" (the default) goes in queue order, "devices" favours users
+// with the most devices in the JSS and "attribute" goes by where the
+// user's PriorityAttribute value falls in PriorityValues. A warning is
+// logged each time usage rises past one of the WarnAt percentages.
+type QuotaConfig struct {
+	Threshold         int
+	Action            string
+	Priority          string
+	PriorityAttribute string
+	PriorityValues    []string
+	WarnAt            []int
+}
+
+// TracingConfig chooses where OpenTelemetry spans are sent. Exporter is
+// "otlp" to send them over OTLP/HTTP to Endpoint (host:port, or the
+// OTEL_EXPORTER_OTLP_ENDPOINT environment variable when empty), "stdout"
+// to print them for local testing, or empty to turn tracing off.
+// SampleRatio is the fraction of traces kept, all of them when 0.
+type TracingConfig struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
+}
+
+// LoggingConfig sets up Mudwork's log. Format is "text" (the default)
+// or "json", Level is a logrus level name, info by default, and File is
+// a path to append to instead of stdout.
+type LoggingConfig struct {
+	Format string
+	Level  string
+	File   string
+}
+
+// EligibilityConfig holds the rules a user's directory entry must meet
+// before they are licensed. Match is "all" (the default) or "any".
+// Action is "reject" (the default) to refuse ineligible users or
+// "review" to hold them for an administrator.
+type EligibilityConfig struct {
+	Match  string
+	Action string
+	Rules  []EligibilityRule
+}
+
+// EligibilityRule is met when Attribute has at least one of Values,
+// compared without regard to case. Group membership can be checked
+// with Attribute = "memberOf" and the group's DN as a value.
+type EligibilityRule struct {
+	Attribute string
+	Values    []string
+}
+
+// AccountTypes map
+//      uid            string = federatedID, enterpriseID or adobeID
+
 // Server map
 //      Host           string
 //      Endpoint       string
@@ -44,21 +222,29 @@ var FlagProd *bool
 var FlagNoInit *bool
 var FlagTestMode *bool
 var FlagPort *int
+var FlagUser *string
+var FlagReview *bool
+var FlagApprove *string
+var FlagSources *string
 
 func init() {
-        var err error
-        configPath := flag.String("config", "./config/mudwork.toml", "use -config to specify the config file to load")
-        FlagGroups = flag.Bool("groups", false, "query Adobe for a list of each group, print then quit")
-        FlagNoInit = flag.Bool("noinit", false, "set -noinit if a token does not need to be initialized")
-        FlagTestMode = flag.Bool("testmode", false, "Sends Adobe requests in test mode")
-        FlagProd = flag.Bool("prod", false, "set -prod for persistent storage / production server")
-        FlagPort = flag.Int("p", 8443, "sets the port number for mudwork to listen on")
-        flag.Parse()
-        if *configPath == "" {
-                log.Fatal("Could not load config. Please use -config to specify a config file")
-        }
-        _, err = toml.DecodeFile(*configPath, &C)
-        if err != nil {
-                panic(err)
-        }
+	var err error
+	configPath := flag.String("config", "./config/mudwork.toml", "use -config to specify the config file to load")
+	FlagGroups = flag.Bool("groups", false, "query Adobe for a list of each group, print then quit")
+	FlagNoInit = flag.Bool("noinit", false, "set -noinit if a token does not need to be initialized")
+	FlagTestMode = flag.Bool("testmode", false, "Sends Adobe requests in test mode")
+	FlagProd = flag.Bool("prod", false, "set -prod for persistent storage / production server")
+	FlagPort = flag.Int("p", 8443, "sets the port number for mudwork to listen on")
+	FlagReview = flag.Bool("review", false, "print users held for eligibility review, then quit")
+	FlagApprove = flag.String("approve", "", "approve a user held for review and queue their license, then quit")
+	FlagSources = flag.String("sources", "", "print the devices that justify a user's license, then quit")
+	FlagUser = flag.String("user", "", "query Adobe for a user's account and groups, print then quit")
+	flag.Parse()
+	if *configPath == "" {
+		log.Fatal("Could not load config. Please use -config to specify a config file")
+	}
+	_, err = toml.DecodeFile(*configPath, &C)
+	if err != nil {
+		panic(err)
+	}
 }
@@ -2,6 +2,7 @@ package data
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/cosmouser/mudwork/config"
 	_ "github.com/mattn/go-sqlite3"
 	log "github.com/sirupsen/logrus"
@@ -36,12 +37,65 @@ func init() {
 	create table if not exists users
 	(unique_id varchar(30) not null primary key);
 	create table if not exists txlog
-	(unique_id varchar(30) not null, txtype varchar(30) not null);
+	(unique_id varchar(30) not null, txtype varchar(30) not null,
+	created integer not null default 0);
+	create table if not exists unlicensed
+	(unique_id varchar(30) not null primary key, since integer not null);
+	create table if not exists review
+	(unique_id varchar(30) not null, txtype varchar(30) not null,
+	reason text not null, status varchar(30) not null, created integer not null,
+	primary key (unique_id, txtype));
+	create table if not exists pending_removal
+	(unique_id varchar(30) not null primary key, since integer not null);
+	create table if not exists license_sources
+	(unique_id varchar(30) not null, kind varchar(30) not null,
+	device_id integer not null, device_name text not null,
+	serial_number text not null, source text not null);
+	create index if not exists license_sources_unique_id
+	on license_sources (unique_id);
+	create table if not exists identities
+	(unique_id varchar(30) not null primary key, user text not null,
+	adobe_id integer not null);
+	create table if not exists writeback_devices
+	(unique_id varchar(30) not null, device_id integer not null,
+	primary key (unique_id, device_id));
 	`
 	_, err = Db.Exec(sqlStmt)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// txlogs from before entries were timestamped
+	err = addColumn("txlog", "created", "integer not null default 0")
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// addColumn adds a column to a table created by an earlier version,
+// doing nothing when the table already has it
+func addColumn(table, column, definition string) error {
+	rows, err := Db.Query(fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = Db.Exec(fmt.Sprintf("alter table %s add column %s %s", table, column, definition))
+	return err
 }
 func GetDBSize() float64 {
 	var numPages, pageSize float64
@@ -1,17 +1,84 @@
 package data
-  
+
+// Key identifies a user within a mapping, such as the Adobe product
+// profile they are licensed through. Mudwork manages a single mapping,
+// the AdobeGroup, but keeping it in the key lets one pass diff several.
+type Key struct {
+	Mapping  string
+	UniqueID string
+}
+
+// KeyChanges is the difference between a desired and a current set of
+// keys
+type KeyChanges struct {
+	Add       []Key
+	Remove    []Key
+	Unchanged []Key
+}
+
+// Changes is the difference between a desired and a current set of
+// users
+type Changes struct {
+	Add       []string
+	Remove    []string
+	Unchanged []string
+}
+
+// DiffKeys compares desired with current in a single pass over each.
+// Add and Unchanged keep the order of desired and Remove keeps the
+// order of current, and a key listed twice is reported once.
+func DiffKeys(desired, current []Key) KeyChanges {
+	changes := KeyChanges{Add: []Key{}, Remove: []Key{}, Unchanged: []Key{}}
+	have := make(map[Key]bool, len(current))
+	for _, j := range current {
+		have[j] = true
+	}
+	want := make(map[Key]bool, len(desired))
+	for _, j := range desired {
+		if want[j] {
+			continue
+		}
+		want[j] = true
+		if have[j] {
+			changes.Unchanged = append(changes.Unchanged, j)
+		} else {
+			changes.Add = append(changes.Add, j)
+		}
+	}
+	for _, j := range current {
+		if !want[j] {
+			changes.Remove = append(changes.Remove, j)
+			// report a duplicate once
+			want[j] = true
+		}
+	}
+	return changes
+}
+
+// DiffSets compares desired users with current ones, as DiffKeys does
+// for a single mapping
+func DiffSets(desired, current []string) Changes {
+	kc := DiffKeys(keys(desired), keys(current))
+	return Changes{Add: uids(kc.Add), Remove: uids(kc.Remove), Unchanged: uids(kc.Unchanged)}
+}
+
+func keys(uids []string) []Key {
+	result := make([]Key, len(uids))
+	for i, j := range uids {
+		result[i] = Key{UniqueID: j}
+	}
+	return result
+}
+
+func uids(keys []Key) []string {
+	result := make([]string, len(keys))
+	for i, j := range keys {
+		result[i] = j.UniqueID
+	}
+	return result
+}
+
+// Diff returns the entries of s1 that aren't in s2, in the order of s1
 func Diff(s1, s2 []string) []string {
-        result := []string{}
-        for _, i := range s1 {
-                found := false
-                for _, j := range s2 {
-                        if i == j {
-                                found = true
-                        }
-                }
-                if found == false {
-                        result = append(result, i)
-                }
-        }
-        return result
+	return DiffSets(s1, s2).Add
 }
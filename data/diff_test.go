@@ -0,0 +1,71 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSets(t *testing.T) {
+	desired := []string{"carol", "alice", "dave", "alice", "erin"}
+	current := []string{"bob", "alice", "frank", "bob", "erin"}
+	got := DiffSets(desired, current)
+	want := Changes{
+		Add:       []string{"carol", "dave"},
+		Remove:    []string{"bob", "frank"},
+		Unchanged: []string{"alice", "erin"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v\n", got, want)
+	}
+	if got := Diff(current, desired); !reflect.DeepEqual(got, want.Remove) {
+		t.Errorf("Diff returned %v, wanted %v\n", got, want.Remove)
+	}
+}
+
+func TestDiffKeys(t *testing.T) {
+	desired := []Key{{"cc", "alice"}, {"acrobat", "alice"}}
+	current := []Key{{"cc", "alice"}, {"cc", "bob"}}
+	got := DiffKeys(desired, current)
+	want := KeyChanges{
+		Add:       []Key{{"acrobat", "alice"}},
+		Remove:    []Key{{"cc", "bob"}},
+		Unchanged: []Key{{"cc", "alice"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, wanted %+v\n", got, want)
+	}
+}
+
+// campus returns n uids starting from offset, so two campuses overlap
+// where their ranges do
+func campus(n, offset int) []string {
+	result := make([]string, n)
+	for i := range result {
+		result[i] = fmt.Sprintf("user%06d", i+offset)
+	}
+	return result
+}
+
+func BenchmarkDiffSets(b *testing.B) {
+	for _, n := range []int{1000, 10000, 50000} {
+		desired, current := campus(n, 0), campus(n, n/10)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				DiffSets(desired, current)
+			}
+		})
+	}
+}
+
+func BenchmarkDiffKeys(b *testing.B) {
+	desired, current := keys(campus(50000, 0)), keys(campus(50000, 5000))
+	for i := range desired {
+		desired[i].Mapping = "cc"
+		current[i].Mapping = "cc"
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffKeys(desired, current)
+	}
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"database/sql"
+	log "github.com/sirupsen/logrus"
+)
+
+// Identity is how a user's Adobe account was addressed when it was
+// created: by the email it was created with, and as an Adobe ID or an
+// account owned by the org
+type Identity struct {
+	UniqueID string
+	User     string
+	AdobeID  bool
+}
+
+// SetIdentity records the identity a user's account was created with,
+// replacing any recorded before
+func SetIdentity(id Identity) error {
+	_, err := Db.Exec("insert or replace into identities(unique_id, user, adobe_id) values(?, ?, ?)", id.UniqueID, id.User, id.AdobeID)
+	return err
+}
+
+// LookupIdentity returns the identity recorded for uid, or nil for users
+// licensed before identities were recorded
+func LookupIdentity(uid string) *Identity {
+	id := &Identity{UniqueID: uid}
+	err := Db.QueryRow("select user, adobe_id from identities where unique_id = ?", uid).Scan(&id.User, &id.AdobeID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
+
+// DeleteIdentity forgets a user whose account has left the org
+func DeleteIdentity(uid string) error {
+	_, err := Db.Exec("delete from identities where unique_id = ?", uid)
+	return err
+}
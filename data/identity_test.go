@@ -0,0 +1,23 @@
+package data
+
+import "testing"
+
+func TestIdentity(t *testing.T) {
+	if id := LookupIdentity("vera"); id != nil {
+		t.Fatalf("got %+v before one was recorded, wanted nil\n", id)
+	}
+	want := Identity{UniqueID: "vera", User: "vera.k@alumni.uni.edu", AdobeID: true}
+	if err := SetIdentity(want); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteIdentity("vera")
+	if id := LookupIdentity("vera"); id == nil || *id != want {
+		t.Errorf("got %+v, wanted %+v\n", id, want)
+	}
+	if err := DeleteIdentity("vera"); err != nil {
+		t.Fatal(err)
+	}
+	if id := LookupIdentity("vera"); id != nil {
+		t.Errorf("got %+v after it was deleted, wanted nil\n", id)
+	}
+}
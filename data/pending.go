@@ -0,0 +1,65 @@
+package data
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// MarkPendingRemoval records that uids went missing from the JSS at
+// since. Users already pending keep their earlier time.
+func MarkPendingRemoval(uids []string, since time.Time) error {
+	return execEach("insert or ignore into pending_removal(unique_id, since) values(?, ?)", uids, since.Unix())
+}
+
+// ClearPendingRemoval forgets uids, either because they are back in the
+// JSS or because their removal has been queued
+func ClearPendingRemoval(uids []string) error {
+	return execEach("delete from pending_removal where unique_id = ?", uids)
+}
+
+// GetPendingRemovals returns the time each pending user went missing
+func GetPendingRemovals() map[string]time.Time {
+	pending := make(map[string]time.Time)
+	rows, err := Db.Query("select unique_id, since from pending_removal")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid string
+		var since int64
+		err = rows.Scan(&uid, &since)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pending[uid] = time.Unix(since, 0)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pending
+}
+
+// execEach runs query once for each uid in a single transaction, with
+// the uid followed by args as its parameters
+func execEach(query string, uids []string, args ...interface{}) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, j := range uids {
+		_, err = stmt.Exec(append([]interface{}{j}, args...)...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
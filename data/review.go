@@ -0,0 +1,116 @@
+package data
+
+import (
+	"database/sql"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Review statuses
+const (
+	ReviewPending  = "review"
+	ReviewRejected = "rejected"
+	ReviewApproved = "approved"
+	// ReviewDeadLetter marks a transaction dropped because the
+	// directory confirmed it has no single entry for the user
+	ReviewDeadLetter = "deadletter"
+	// ReviewQuota marks an add held until the AdobeGroup has a seat
+	// free under the license quota
+	ReviewQuota = "quota"
+)
+
+// Review is a transaction that was held back, with the reason why
+type Review struct {
+	UniqueID string
+	TxType   string
+	Reason   string
+	Status   string
+	Created  time.Time
+}
+
+// InsertReview records a held transaction, replacing any earlier
+// record for the same user and txtype
+func InsertReview(r *Review) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("insert or replace into review(unique_id, txtype, reason, status, created) values(?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(r.UniqueID, r.TxType, r.Reason, r.Status, r.Created.Unix())
+	if err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// SetReviewStatus changes the status of a held transaction
+func SetReviewStatus(uid, txType, status string) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("update review set status = ? where unique_id = ? and txtype = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(status, uid, txType)
+	if err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// DeleteReview forgets the held transaction for a user and txtype
+func DeleteReview(uid, txType string) error {
+	_, err := Db.Exec("delete from review where unique_id = ? and txtype = ?", uid, txType)
+	return err
+}
+
+// LookupReview returns the held transaction for a user and txtype, or
+// nil when there isn't one
+func LookupReview(uid, txType string) *Review {
+	r := &Review{}
+	var created int64
+	err := Db.QueryRow("select unique_id, txtype, reason, status, created from review where unique_id = ? and txtype = ?",
+		uid, txType).Scan(&r.UniqueID, &r.TxType, &r.Reason, &r.Status, &created)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Created = time.Unix(created, 0)
+	return r
+}
+
+// GetReviews returns every held transaction with the given status
+func GetReviews(status string) []Review {
+	reviews := []Review{}
+	rows, err := Db.Query("select unique_id, txtype, reason, status, created from review where status = ? order by created", status)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Review
+		var created int64
+		err = rows.Scan(&r.UniqueID, &r.TxType, &r.Reason, &r.Status, &created)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Created = time.Unix(created, 0)
+		reviews = append(reviews, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return reviews
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// LicenseSource is a device that justifies a user's license, and the
+// search or group in the JSS it was found through
+type LicenseSource struct {
+	UniqueID     string `json:"user"`
+	Kind         string `json:"kind"`
+	DeviceID     int    `json:"device_id"`
+	DeviceName   string `json:"device_name"`
+	SerialNumber string `json:"serial_number"`
+	Source       string `json:"source"`
+}
+
+// ReplaceLicenseSources swaps the stored sources for those from the
+// latest sync
+func ReplaceLicenseSources(sources []LicenseSource) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("delete from license_sources"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt, err := tx.Prepare("insert into license_sources(unique_id, kind, device_id, device_name, serial_number, source) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, j := range sources {
+		_, err = stmt.Exec(j.UniqueID, j.Kind, j.DeviceID, j.DeviceName, j.SerialNumber, j.Source)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetLicenseSources returns the devices that justify uid's license
+func GetLicenseSources(uid string) []LicenseSource {
+	return queryLicenseSources("select unique_id, kind, device_id, device_name, serial_number, source from license_sources where unique_id = ? order by kind, device_id, source", uid)
+}
+
+// GetAllLicenseSources returns the sources of every user
+func GetAllLicenseSources() []LicenseSource {
+	return queryLicenseSources("select unique_id, kind, device_id, device_name, serial_number, source from license_sources order by unique_id, kind, device_id, source")
+}
+
+func queryLicenseSources(query string, args ...interface{}) []LicenseSource {
+	sources := []LicenseSource{}
+	rows, err := Db.Query(query, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s LicenseSource
+		err = rows.Scan(&s.UniqueID, &s.Kind, &s.DeviceID, &s.DeviceName, &s.SerialNumber, &s.Source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sources = append(sources, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return sources
+}
@@ -1,7 +1,9 @@
 package data
 
 import (
+	"database/sql"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 type TxEntry struct {
@@ -32,12 +34,12 @@ func InsertTxEntry(txEntry *TxEntry) error {
 	if err != nil {
 		return err
 	}
-	stmt, err := tx.Prepare("insert into txlog(unique_id, txtype) values(?, ?)")
+	stmt, err := tx.Prepare("insert into txlog(unique_id, txtype, created) values(?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(txEntry.UniqueID, txEntry.TxType)
+	_, err = stmt.Exec(txEntry.UniqueID, txEntry.TxType, time.Now().Unix())
 	if err != nil {
 		return err
 	}
@@ -45,6 +47,92 @@ func InsertTxEntry(txEntry *TxEntry) error {
 	return nil
 }
 
+// InsertTxEntries queues many entries in one transaction
+func InsertTxEntries(entries []TxEntry) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("insert into txlog(unique_id, txtype, created) values(?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().Unix()
+	for _, j := range entries {
+		_, err = stmt.Exec(j.UniqueID, j.TxType, now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetQueued returns every entry in the txlog, for checking many
+// entries with one query
+func GetQueued() map[TxEntry]bool {
+	queued := make(map[TxEntry]bool)
+	rows, err := Db.Query("select unique_id, txtype from txlog")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry TxEntry
+		err = rows.Scan(&entry.UniqueID, &entry.TxType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		queued[entry] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return queued
+}
+
+// GetQueueDepth returns the number of entries in the txlog for each
+// txtype
+func GetQueueDepth() map[string]int {
+	depth := make(map[string]int)
+	rows, err := Db.Query("select txtype, count(*) from txlog group by txtype")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var txType string
+		var count int
+		err = rows.Scan(&txType, &count)
+		if err != nil {
+			log.Fatal(err)
+		}
+		depth[txType] = count
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return depth
+}
+
+// GetOldestTxEntry returns when the oldest entry in the txlog was
+// queued, and false when no timestamped entries are queued
+func GetOldestTxEntry() (time.Time, bool) {
+	var created sql.NullInt64
+	err := Db.QueryRow("select min(created) from txlog where created > 0").Scan(&created)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !created.Valid {
+		return time.Time{}, false
+	}
+	return time.Unix(created.Int64, 0), true
+}
+
 // DeleteTxEntry deletes a TxEntry
 func DeleteTxEntry(txEntry *TxEntry) error {
 	tx, err := Db.Begin()
@@ -2,6 +2,7 @@ package data
 
 import (
 	"testing"
+	"time"
 )
 
 func TestTxEntries(t *testing.T) {
@@ -105,3 +106,55 @@ func TestGetTxEntries(t *testing.T) {
 	}
 
 }
+
+func TestGetQueued(t *testing.T) {
+	entries := []TxEntry{{UniqueID: "quinn", TxType: "add"}, {UniqueID: "rosa", TxType: "remove"}}
+	if err := InsertTxEntries(entries); err != nil {
+		t.Fatal(err)
+	}
+	queued := GetQueued()
+	for _, j := range entries {
+		if !queued[j] {
+			t.Errorf("GetQueued is missing %+v\n", j)
+		}
+		DeleteTxEntry(&j)
+	}
+	if queued[TxEntry{UniqueID: "quinn", TxType: "remove"}] {
+		t.Error("GetQueued matched the wrong txtype")
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	entries := []TxEntry{{UniqueID: "sven", TxType: "add"}, {UniqueID: "tomas", TxType: "add"}, {UniqueID: "ulla", TxType: "update"}}
+	if err := InsertTxEntries(entries); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, j := range entries {
+			DeleteTxEntry(&j)
+		}
+	}()
+	depth := GetQueueDepth()
+	if depth["add"] != 2 || depth["update"] != 1 {
+		t.Errorf("got depth %v, wanted 2 adds and 1 update\n", depth)
+	}
+	oldest, ok := GetOldestTxEntry()
+	if !ok || oldest.After(time.Now()) || time.Since(oldest) > time.Hour {
+		t.Errorf("got oldest %v %v, wanted the time they were queued\n", oldest, ok)
+	}
+}
+
+func TestAddColumn(t *testing.T) {
+	if _, err := Db.Exec("create table oldlog (unique_id varchar(30) not null)"); err != nil {
+		t.Fatal(err)
+	}
+	defer Db.Exec("drop table oldlog")
+	for i := 0; i < 2; i++ {
+		if err := addColumn("oldlog", "created", "integer not null default 0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Db.Exec("insert into oldlog(unique_id, created) values('vera', 1)"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// InsertUnlicensed records that a user lost their license at since.
+// Recording a user again moves the time forward.
+func InsertUnlicensed(uid string, since time.Time) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("insert or replace into unlicensed(unique_id, since) values(?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(uid, since.Unix())
+	if err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// DeleteUnlicensed forgets a user, either because they were licensed
+// again or because they were removed from the org
+func DeleteUnlicensed(uid string) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("delete from unlicensed where unique_id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(uid)
+	if err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+// GetUnlicensedBefore returns the users who have been without a license
+// since before cutoff
+func GetUnlicensedBefore(cutoff time.Time) []string {
+	names := []string{}
+	rows, err := Db.Query("select unique_id from unlicensed where since < ?", cutoff.Unix())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return names
+}
@@ -0,0 +1,49 @@
+package data
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// SetWriteBackDevices records the computers a user's license status was
+// last written to, replacing any recorded before
+func SetWriteBackDevices(uid string, ids []int) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("delete from writeback_devices where unique_id = ?", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, j := range ids {
+		if _, err = tx.Exec("insert into writeback_devices(unique_id, device_id) values(?, ?)", uid, j); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetWriteBackDevices returns the computers a user's license status was
+// last written to
+func GetWriteBackDevices(uid string) []int {
+	ids := []int{}
+	rows, err := Db.Query("select device_id from writeback_devices where unique_id = ? order by device_id", uid)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ids
+}
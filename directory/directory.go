@@ -0,0 +1,178 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"github.com/cosmouser/mudwork/logging"
+	"github.com/cosmouser/mudwork/tracing"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"strings"
+)
+
+// Directory is a source of people. GetPeople returns a Result for every
+// uid, and an error only when the source couldn't be asked.
+type Directory interface {
+	Name() string
+	GetPeople(ctx context.Context, uids []string) (map[string]ldapsearch.Result, error)
+}
+
+// EmailResolver is a Directory that can find users by email address.
+// GetUidsByEmail is keyed by the lower case email and leaves out
+// addresses it can't tie to a single uid.
+type EmailResolver interface {
+	GetUidsByEmail(ctx context.Context, emails []string) (map[string]string, error)
+}
+
+// Chain asks each directory in turn for the uids the ones before it
+// didn't know. A uid found in more than one entry of a directory is not
+// passed on, and Person.Source records which directory answered.
+type Chain []Directory
+
+// Default is built from Directories, which defaults to the override
+// file, when OverrideFile is set, followed by LDAP
+var Default Chain
+
+func init() {
+	names := config.C.Directories
+	if len(names) == 0 {
+		names = []string{"ldap"}
+		if config.C.OverrideFile != "" {
+			names = []string{"override", "ldap"}
+		}
+	}
+	for _, j := range names {
+		d, err := New(j)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"directory": j,
+			}).Warn("Unknown directory in Directories")
+			continue
+		}
+		Default = append(Default, d)
+	}
+}
+
+// New returns the directory called name: "ldap", "ad" or "override"
+func New(name string) (Directory, error) {
+	switch name {
+	case "ldap":
+		return ldapsearch.Default, nil
+	case "ad":
+		return ldapsearch.NewActiveDirectory(), nil
+	case "override":
+		return NewFile(config.C.OverrideFile), nil
+	}
+	return nil, fmt.Errorf("directory: unknown directory %q", name)
+}
+
+// Valid reports whether name is a directory New knows
+func Valid(name string) bool {
+	switch name {
+	case "ldap", "ad", "override":
+		return true
+	}
+	return false
+}
+
+// GetPerson looks up a single user through the Default chain
+func GetPerson(ctx context.Context, uid string) (*ldapsearch.Person, error) {
+	return Default.getPerson(ctx, uid)
+}
+
+// GetPeople looks up many users at once through the Default chain
+func GetPeople(ctx context.Context, uids []string) (map[string]ldapsearch.Result, error) {
+	return Default.GetPeople(ctx, uids)
+}
+
+// GetUidsByEmail finds users by email through the Default chain
+func GetUidsByEmail(ctx context.Context, emails []string) (map[string]string, error) {
+	return Default.GetUidsByEmail(ctx, emails)
+}
+
+// Name lists the directories in the chain
+func (c Chain) Name() string {
+	names := make([]string, len(c))
+	for i, j := range c {
+		names[i] = j.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c Chain) getPerson(ctx context.Context, uid string) (*ldapsearch.Person, error) {
+	results, err := c.GetPeople(ctx, []string{uid})
+	if err != nil {
+		return nil, err
+	}
+	r := results[uid]
+	return r.Person, r.Err
+}
+
+// GetPeople returns a Result for every uid. Directories later in the
+// chain are only asked once an earlier one reports ErrNotFound, so an
+// unavailable directory fails the lookup only when it is needed.
+func (c Chain) GetPeople(ctx context.Context, uids []string) (results map[string]ldapsearch.Result, err error) {
+	ctx, span := tracing.Start(ctx, "directory.lookup", attribute.Int("directory.uids", len(uids)))
+	defer func() { tracing.End(span, err) }()
+	results = make(map[string]ldapsearch.Result)
+	for _, j := range uids {
+		results[j] = ldapsearch.Result{Err: ldapsearch.ErrNotFound}
+	}
+	pending := uids
+	for _, d := range c {
+		if len(pending) == 0 {
+			break
+		}
+		found, err := d.GetPeople(ctx, pending)
+		if err != nil {
+			return nil, err
+		}
+		next := []string{}
+		for _, j := range pending {
+			r, ok := found[j]
+			if !ok || (r.Person == nil && r.Err == nil) || r.Err == ldapsearch.ErrNotFound {
+				next = append(next, j)
+				continue
+			}
+			results[j] = r
+			if r.Person != nil {
+				logging.From(ctx).WithFields(log.Fields{
+					"uid":    j,
+					"source": r.Person.Source,
+				}).Debug("Found user in directory")
+			}
+		}
+		pending = next
+	}
+	span.SetAttributes(attribute.Int("directory.not_found", len(pending)))
+	return results, nil
+}
+
+// GetUidsByEmail asks each directory that can search by email for the
+// addresses the ones before it couldn't resolve
+func (c Chain) GetUidsByEmail(ctx context.Context, emails []string) (map[string]string, error) {
+	uids := make(map[string]string)
+	pending := emails
+	for _, d := range c {
+		r, ok := d.(EmailResolver)
+		if !ok || len(pending) == 0 {
+			continue
+		}
+		found, err := r.GetUidsByEmail(ctx, pending)
+		if err != nil {
+			return nil, err
+		}
+		next := []string{}
+		for _, j := range pending {
+			if uid, ok := found[strings.ToLower(j)]; ok {
+				uids[strings.ToLower(j)] = uid
+			} else {
+				next = append(next, j)
+			}
+		}
+		pending = next
+	}
+	return uids, nil
+}
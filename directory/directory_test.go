@@ -0,0 +1,110 @@
+package directory
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fake struct {
+	name   string
+	people map[string]*ldapsearch.Person
+	err    error
+	asked  []string
+}
+
+func (f *fake) Name() string { return f.name }
+
+func (f *fake) GetPeople(ctx context.Context, uids []string) (map[string]ldapsearch.Result, error) {
+	f.asked = append(f.asked, uids...)
+	if f.err != nil {
+		return nil, f.err
+	}
+	results := make(map[string]ldapsearch.Result)
+	for _, j := range uids {
+		if p, ok := f.people[j]; ok {
+			results[j] = ldapsearch.Result{Person: p}
+		} else {
+			results[j] = ldapsearch.Result{Err: ldapsearch.ErrNotFound}
+		}
+	}
+	return results, nil
+}
+
+func TestChain(t *testing.T) {
+	override := &fake{name: "override", people: map[string]*ldapsearch.Person{
+		"jdoe": {Uid: "jdoe", FirstName: "Jane", Source: "override"},
+	}}
+	ldap := &fake{name: "ldap", people: map[string]*ldapsearch.Person{
+		"jdoe":   {Uid: "jdoe", FirstName: "Janet", Source: "ldap"},
+		"asmith": {Uid: "asmith", FirstName: "Alex", Source: "ldap"},
+	}}
+	results, err := Chain{override, ldap}.GetPeople(context.Background(), []string{"jdoe", "asmith", "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := results["jdoe"].Person; p == nil || p.Source != "override" {
+		t.Errorf("got %+v for jdoe, wanted the override entry\n", results["jdoe"])
+	}
+	if p := results["asmith"].Person; p == nil || p.Source != "ldap" {
+		t.Errorf("got %+v for asmith, wanted the ldap entry\n", results["asmith"])
+	}
+	if err := results["gone"].Err; err != ldapsearch.ErrNotFound {
+		t.Errorf("got %v for gone, wanted ErrNotFound\n", err)
+	}
+	if len(ldap.asked) != 2 {
+		t.Errorf("ldap was asked for %v, wanted only the uids the override didn't have\n", ldap.asked)
+	}
+
+	down := &fake{name: "ldap", err: &ldapsearch.UnavailableError{Err: os.ErrClosed}}
+	if _, err := (Chain{override, down}).GetPeople(context.Background(), []string{"jdoe"}); err != nil {
+		t.Errorf("got %v, wanted the override to answer without asking ldap\n", err)
+	}
+	if _, err := (Chain{override, down}).GetPeople(context.Background(), []string{"asmith"}); !ldapsearch.IsUnavailable(err) {
+		t.Errorf("got %v, wanted an unavailable error\n", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mudwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	csvPath := filepath.Join(dir, "override.csv")
+	ioutil.WriteFile(csvPath, []byte("uid,firstName,lastName,email,country,eduPersonAffiliation\n"+
+		"# visiting scholar\n"+
+		"JDoe,Jane,Doe,jane@uni.edu,ca,staff\n"), 0644)
+	results, err := NewFile(csvPath).GetPeople(context.Background(), []string{"jdoe", "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := results["jdoe"].Person
+	if p == nil || p.FirstName != "Jane" || p.Email != "jane@uni.edu" || p.Country != "CA" || p.Source != "override" {
+		t.Errorf("got %+v for jdoe\n", results["jdoe"])
+	} else if p.Value([]string{"eduPersonAffiliation"}) != "staff" {
+		t.Errorf("got attributes %v, wanted eduPersonAffiliation kept\n", p.Attributes)
+	}
+	if results["gone"].Err != ldapsearch.ErrNotFound {
+		t.Errorf("got %+v for gone, wanted ErrNotFound\n", results["gone"])
+	}
+	uids, err := (Chain{NewFile(csvPath)}).GetUidsByEmail(context.Background(), []string{"Jane@uni.edu", "nobody@uni.edu"})
+	if err != nil || len(uids) != 1 || uids["jane@uni.edu"] != "jdoe" {
+		t.Errorf("got %v, %v, wanted jane@uni.edu resolved to jdoe\n", uids, err)
+	}
+
+	tomlPath := filepath.Join(dir, "override.toml")
+	ioutil.WriteFile(tomlPath, []byte("[asmith]\nfirstName = \"Alex\"\nmemberOf = [\"cn=a\", \"cn=b\"]\n"), 0644)
+	p, err = (Chain{NewFile(tomlPath)}).getPerson(context.Background(), "asmith")
+	if err != nil || p.FirstName != "Alex" || len(p.Attributes["memberOf"]) != 2 {
+		t.Errorf("got %+v, %v for asmith\n", p, err)
+	}
+
+	if _, err := NewFile(filepath.Join(dir, "missing.csv")).GetPeople(context.Background(), []string{"jdoe"}); err != nil {
+		t.Errorf("got %v, wanted a missing file to have no entries\n", err)
+	}
+}
@@ -0,0 +1,187 @@
+package directory
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// overrideAttributes maps Person fields to the columns of an override
+// file
+var overrideAttributes = ldapsearch.AttributeMap{
+	Email:     []string{"email"},
+	FirstName: []string{"firstName"},
+	LastName:  []string{"lastName"},
+	Country:   []string{"country"},
+}
+
+// File is a directory kept in a CSV or TOML file that ops can edit to
+// correct or add entries. A CSV file has a header row with a uid column
+// and any of email, firstName, lastName and country; other columns are
+// kept as attributes for eligibility rules. A TOML file has a table per
+// uid with the same keys. The file is read again whenever it changes,
+// and a missing file has no entries.
+type File struct {
+	path string
+
+	mu       sync.Mutex
+	modified time.Time
+	people   map[string]map[string][]string
+}
+
+// NewFile returns the override directory kept at path
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Name identifies the directory, as recorded in Person.Source
+func (f *File) Name() string {
+	return "override"
+}
+
+// GetPeople looks uids up without regard to case. A file that can't be
+// read or parsed is reported as an *ldapsearch.UnavailableError.
+func (f *File) GetPeople(ctx context.Context, uids []string) (map[string]ldapsearch.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.load(); err != nil {
+		return nil, &ldapsearch.UnavailableError{Err: err}
+	}
+	results := make(map[string]ldapsearch.Result)
+	for _, j := range uids {
+		attrs, ok := f.people[strings.ToLower(j)]
+		if !ok {
+			results[j] = ldapsearch.Result{Err: ldapsearch.ErrNotFound}
+			continue
+		}
+		results[j] = ldapsearch.Result{Person: ldapsearch.NewPerson(j, f.Name(), attrs, overrideAttributes)}
+	}
+	return results, nil
+}
+
+// load reads the file if it has changed since it was last read
+func (f *File) load() error {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		f.people, f.modified = nil, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if f.people != nil && info.ModTime().Equal(f.modified) {
+		return nil
+	}
+	var people map[string]map[string][]string
+	if strings.EqualFold(filepath.Ext(f.path), ".toml") {
+		people, err = readTOML(f.path)
+	} else {
+		people, err = readCSV(f.path)
+	}
+	if err != nil {
+		return fmt.Errorf("directory: unable to read %s: %v", f.path, err)
+	}
+	f.people, f.modified = people, info.ModTime()
+	return nil
+}
+
+func readCSV(path string) (map[string]map[string][]string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	r := csv.NewReader(fh)
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	uidColumn := -1
+	for i, j := range header {
+		if strings.EqualFold(j, "uid") {
+			uidColumn = i
+		}
+	}
+	if uidColumn < 0 {
+		return nil, fmt.Errorf("no uid column")
+	}
+	people := make(map[string]map[string][]string)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return people, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		attrs := make(map[string][]string)
+		for i, j := range record {
+			if i != uidColumn && j != "" {
+				attrs[header[i]] = []string{j}
+			}
+		}
+		people[strings.ToLower(record[uidColumn])] = attrs
+	}
+}
+
+func readTOML(path string) (map[string]map[string][]string, error) {
+	var tables map[string]map[string]interface{}
+	if _, err := toml.DecodeFile(path, &tables); err != nil {
+		return nil, err
+	}
+	people := make(map[string]map[string][]string)
+	for uid, table := range tables {
+		attrs := make(map[string][]string)
+		for k, v := range table {
+			switch v := v.(type) {
+			case string:
+				attrs[k] = []string{v}
+			case []interface{}:
+				for _, j := range v {
+					attrs[k] = append(attrs[k], fmt.Sprint(j))
+				}
+			default:
+				attrs[k] = []string{fmt.Sprint(v)}
+			}
+		}
+		people[strings.ToLower(uid)] = attrs
+	}
+	return people, nil
+}
+
+// GetUidsByEmail finds the uids whose email column holds one of emails
+func (f *File) GetUidsByEmail(ctx context.Context, emails []string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.load(); err != nil {
+		return nil, &ldapsearch.UnavailableError{Err: err}
+	}
+	wanted := make(map[string]bool)
+	for _, j := range emails {
+		wanted[strings.ToLower(j)] = true
+	}
+	uids := make(map[string]string)
+	for uid, attrs := range f.people {
+		for k, values := range attrs {
+			if !strings.EqualFold(k, "email") {
+				continue
+			}
+			for _, j := range values {
+				if wanted[strings.ToLower(j)] {
+					uids[strings.ToLower(j)] = uid
+				}
+			}
+		}
+	}
+	return uids, nil
+}
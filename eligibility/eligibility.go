@@ -0,0 +1,64 @@
+package eligibility
+
+import (
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"strings"
+)
+
+// Check evaluates the configured rules against a person's directory
+// entry. It returns true when the person may be licensed, otherwise
+// false and the reason. With no rules configured everyone is eligible.
+func Check(person *ldapsearch.Person) (bool, string) {
+	return check(config.C.Eligibility, person)
+}
+
+// Review reports whether ineligible users are held for review rather
+// than rejected
+func Review() bool {
+	return config.C.Eligibility.Action == "review"
+}
+
+func check(c config.EligibilityConfig, person *ldapsearch.Person) (bool, string) {
+	if len(c.Rules) == 0 {
+		return true, ""
+	}
+	reasons := []string{}
+	for _, rule := range c.Rules {
+		if matches(rule, person) {
+			if c.Match == "any" {
+				return true, ""
+			}
+			continue
+		}
+		reason := fmt.Sprintf("%s not in [%s]", rule.Attribute, strings.Join(rule.Values, ", "))
+		if c.Match != "any" {
+			return false, reason
+		}
+		reasons = append(reasons, reason)
+	}
+	if c.Match == "any" {
+		return false, strings.Join(reasons, "; ")
+	}
+	return true, ""
+}
+
+// matches reports whether any of the person's values for the rule's
+// attribute is one of the rule's values
+func matches(rule config.EligibilityRule, person *ldapsearch.Person) bool {
+	for name, values := range person.Attributes {
+		// attribute names are case insensitive in LDAP
+		if !strings.EqualFold(name, rule.Attribute) {
+			continue
+		}
+		for _, v := range values {
+			for _, want := range rule.Values {
+				if strings.EqualFold(v, want) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
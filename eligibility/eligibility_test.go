@@ -0,0 +1,42 @@
+package eligibility
+
+import (
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	rules := []config.EligibilityRule{
+		{Attribute: "eduPersonAffiliation", Values: []string{"faculty", "staff", "student"}},
+		{Attribute: "accountStatus", Values: []string{"active"}},
+	}
+	student := &ldapsearch.Person{Uid: "jdoe", Attributes: map[string][]string{
+		"eduPersonAffiliation": {"member", "Student"},
+		"accountstatus":        {"active"},
+	}}
+	alum := &ldapsearch.Person{Uid: "asmith", Attributes: map[string][]string{
+		"eduPersonAffiliation": {"alum"},
+		"accountStatus":        {"active"},
+	}}
+	tests := []struct {
+		match  string
+		person *ldapsearch.Person
+		want   bool
+		reason string
+	}{
+		{"all", student, true, ""},
+		{"all", alum, false, "eduPersonAffiliation not in [faculty, staff, student]"},
+		{"any", alum, true, ""},
+		{"any", &ldapsearch.Person{Uid: "nobody"}, false, "eduPersonAffiliation not in [faculty, staff, student]; accountStatus not in [active]"},
+	}
+	for _, tt := range tests {
+		got, reason := check(config.EligibilityConfig{Match: tt.match, Rules: rules}, tt.person)
+		if got != tt.want || reason != tt.reason {
+			t.Errorf("%s %s: got %v %q, wanted %v %q\n", tt.match, tt.person.Uid, got, reason, tt.want, tt.reason)
+		}
+	}
+	if ok, _ := check(config.EligibilityConfig{}, alum); !ok {
+		t.Error("no rules should make everyone eligible")
+	}
+}
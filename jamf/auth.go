@@ -0,0 +1,290 @@
+package jamf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/logging"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/cosmouser/mudwork/tracing"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth modes for the JSS. Basic sends ApiUser and ApiPass with every
+// Classic API request, Token exchanges them for a Jamf Pro API bearer
+// token and Client uses the ApiClientID and ApiClientSecret of an API
+// client. The Jamf Pro API only takes bearer tokens, so in Basic mode
+// its requests use a token for ApiUser.
+const (
+	AuthBasic  = "basic"
+	AuthToken  = "token"
+	AuthClient = "client"
+)
+
+// ValidAuth reports whether mode is a JamfAuth Mudwork supports
+func ValidAuth(mode string) bool {
+	switch mode {
+	case AuthBasic, AuthToken, AuthClient:
+		return true
+	}
+	return false
+}
+
+// Session authorizes requests to the JSS. In the token modes it holds
+// the current bearer token, asks for a new one shortly before it
+// expires and, for user tokens, extends it with keep-alive once half
+// its lifetime has passed.
+type Session struct {
+	mode   string
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	issued  time.Time
+	expires time.Time
+}
+
+// tokenResponse is returned by /api/v1/auth/token and keep-alive
+type tokenResponse struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// clientTokenResponse is returned by /api/oauth/token
+type clientTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Auth is the session used for every request to the JSS, in the mode
+// given by JamfAuth
+var Auth *Session
+
+func init() {
+	mode := config.C.JamfAuth
+	if mode == "" {
+		mode = AuthBasic
+	}
+	Auth = NewSession(mode)
+}
+
+// NewSession returns a session that authorizes requests using mode
+func NewSession(mode string) *Session {
+	return &Session{
+		mode:   mode,
+		client: &http.Client{Timeout: time.Second * 10},
+		now:    time.Now,
+	}
+}
+
+// Get requests path from the JSS, asking for XML from the Classic API
+// and JSON from the Jamf Pro API
+func (s *Session) Get(ctx context.Context, path string) (*http.Response, error) {
+	return s.Do(ctx, "GET", path, nil)
+}
+
+// Do sends a request for path to the JSS. The body and response are XML
+// for the Classic API and JSON for the Jamf Pro API. A request turned
+// away with 401 while using a bearer token is retried once with a new
+// token.
+func (s *Session) Do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, config.C.JssUrl+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		contentType := "application/xml"
+		if proAPI(req) {
+			contentType = "application/json"
+		}
+		req.Header.Set("Accept", contentType)
+		if body != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if err := s.authorize(req); err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && s.usesBearer(req) && attempt == 0 {
+			resp.Body.Close()
+			s.invalidate()
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// proAPI reports whether req is for the Jamf Pro API
+func proAPI(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/api/")
+}
+
+// usesBearer reports whether req is sent with a bearer token
+func (s *Session) usesBearer(req *http.Request) bool {
+	return s.mode != AuthBasic || proAPI(req)
+}
+
+// authorize adds credentials for the session's mode to req
+func (s *Session) authorize(req *http.Request) error {
+	if !s.usesBearer(req) {
+		req.SetBasicAuth(config.C.ApiUser, config.C.ApiPass)
+		return nil
+	}
+	token, err := s.bearer(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// bearer returns a token with at least a minute left, renewing the one
+// held when it is about to expire
+func (s *Session) bearer(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.token != "" && now.Add(time.Minute).Before(s.expires) {
+		halfLife := s.issued.Add(s.expires.Sub(s.issued) / 2)
+		if s.mode != AuthClient && now.After(halfLife) {
+			ctx, span := tracing.Start(ctx, "jamf.token",
+				attribute.String("jamf.auth", s.mode),
+				attribute.Bool("jamf.keep_alive", true))
+			err := s.keepAlive(ctx)
+			tracing.End(span, err)
+			if err != nil {
+				metrics.TokenRefreshFailures.WithLabelValues("jamf").Inc()
+				logging.From(ctx).WithFields(log.Fields{
+					"error": err,
+				}).Warn("Unable to keep JSS token alive")
+			}
+		}
+		return s.token, nil
+	}
+	ctx, span := tracing.Start(ctx, "jamf.token", attribute.String("jamf.auth", s.mode))
+	var err error
+	defer func() { tracing.End(span, err) }()
+	switch s.mode {
+	case AuthBasic, AuthToken:
+		err = s.requestToken(ctx)
+	case AuthClient:
+		err = s.requestClientToken(ctx)
+	default:
+		err = fmt.Errorf("jamf: unknown JamfAuth %q", s.mode)
+	}
+	if err != nil {
+		metrics.TokenRefreshFailures.WithLabelValues("jamf").Inc()
+		return "", err
+	}
+	return s.token, nil
+}
+
+// invalidate drops the current token so the next request gets a new one
+func (s *Session) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// requestToken exchanges ApiUser and ApiPass for a bearer token
+func (s *Session) requestToken(ctx context.Context) error {
+	req, err := http.NewRequest("POST", config.C.JssUrl+"/api/v1/auth/token", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(config.C.ApiUser, config.C.ApiPass)
+	return s.fetchToken(req)
+}
+
+// keepAlive extends the current user token
+func (s *Session) keepAlive(ctx context.Context) error {
+	req, err := http.NewRequest("POST", config.C.JssUrl+"/api/v1/auth/keep-alive", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	return s.fetchToken(req)
+}
+
+// fetchToken sends a token or keep-alive request and stores the token
+// it returns
+func (s *Session) fetchToken(req *http.Request) error {
+	req.Header.Set("Accept", "application/json")
+	body, err := s.post(req)
+	if err != nil {
+		return err
+	}
+	tr := tokenResponse{}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return err
+	}
+	if tr.Token == "" {
+		return fmt.Errorf("jamf: %s returned no token", req.URL.Path)
+	}
+	s.token, s.issued, s.expires = tr.Token, s.now(), tr.Expires
+	logging.SetSecret("jamf.token", s.token)
+	return nil
+}
+
+// requestClientToken gets a token for the API client ApiClientID
+func (s *Session) requestClientToken(ctx context.Context) error {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", config.C.ApiClientID)
+	form.Set("client_secret", config.C.ApiClientSecret)
+	req, err := http.NewRequest("POST", config.C.JssUrl+"/api/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	body, err := s.post(req)
+	if err != nil {
+		return err
+	}
+	cr := clientTokenResponse{}
+	if err := json.Unmarshal(body, &cr); err != nil {
+		return err
+	}
+	if cr.AccessToken == "" {
+		return fmt.Errorf("jamf: /api/oauth/token returned no token")
+	}
+	now := s.now()
+	s.token, s.issued, s.expires = cr.AccessToken, now, now.Add(time.Duration(cr.ExpiresIn)*time.Second)
+	logging.SetSecret("jamf.token", s.token)
+	return nil
+}
+
+// post sends req and returns the body of a 200 response
+func (s *Session) post(req *http.Request) ([]byte, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(req.URL.Path, resp); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
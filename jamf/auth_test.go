@@ -0,0 +1,93 @@
+package jamf
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cosmouser/mudwork/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionToken(t *testing.T) {
+	clock := time.Date(2018, time.November, 5, 12, 0, 0, 0, time.UTC)
+	var issued, keptAlive int
+	reject := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/token":
+			if user, pass, _ := r.BasicAuth(); user != "mudwork" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			issued++
+			json.NewEncoder(w).Encode(tokenResponse{Token: "t1", Expires: clock.Add(time.Minute * 30)})
+		case "/api/v1/auth/keep-alive":
+			keptAlive++
+			json.NewEncoder(w).Encode(tokenResponse{Token: "t2", Expires: clock.Add(time.Minute * 60)})
+		default:
+			if reject || r.Header.Get("Authorization") == "" {
+				reject = false
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("<ok/>"))
+		}
+	}))
+	defer ts.Close()
+	saved := config.C
+	defer func() { config.C = saved }()
+	config.C.JssUrl, config.C.ApiUser, config.C.ApiPass = ts.URL, "mudwork", "secret"
+
+	s := NewSession(AuthToken)
+	s.now = func() time.Time { return clock }
+	for i := 0; i < 2; i++ {
+		resp, err := s.Get(context.Background(), "/JSSResource/advancedcomputersearches/id/1")
+		if err != nil || resp.StatusCode != 200 {
+			t.Fatalf("got %v, %v\n", resp, err)
+		}
+		resp.Body.Close()
+	}
+	if issued != 1 {
+		t.Errorf("requested %d tokens, wanted the first one reused\n", issued)
+	}
+
+	clock = clock.Add(time.Minute * 20)
+	s.Get(context.Background(), "/")
+	if keptAlive != 1 || s.token != "t2" {
+		t.Errorf("keep-alive called %d times with token %s, wanted it extended past half life\n", keptAlive, s.token)
+	}
+
+	reject = true
+	resp, err := s.Get(context.Background(), "/")
+	if err != nil || resp.StatusCode != 200 || issued != 2 {
+		t.Errorf("got %v, %v after %d tokens, wanted a 401 to renew the token\n", resp, err, issued)
+	}
+}
+
+func TestSessionClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/oauth/token" {
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "id" || r.Form.Get("client_secret") != "shh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(clientTokenResponse{AccessToken: "c1", ExpiresIn: 60 * 20})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer c1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+	saved := config.C
+	defer func() { config.C = saved }()
+	config.C.JssUrl, config.C.ApiClientID, config.C.ApiClientSecret = ts.URL, "id", "shh"
+
+	resp, err := NewSession(AuthClient).Get(context.Background(), "/")
+	if err != nil || resp.StatusCode != 200 {
+		t.Errorf("got %v, %v, wanted the client token accepted\n", resp, err)
+	}
+}
@@ -0,0 +1,124 @@
+package jamf
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Device kinds
+const (
+	KindComputer = "computer"
+	KindMobile   = "mobile"
+)
+
+// Device is a computer or mobile device whose user should be licensed,
+// with the search or group it was found through
+type Device struct {
+	Kind         string
+	ID           int
+	Name         string
+	SerialNumber string
+	Username     string
+	Source       string
+}
+
+// GetDevices returns the devices from every advanced search and group
+// in the config. A device found through several of them is listed once
+// for each.
+func GetDevices(ctx context.Context) ([]Device, error) {
+	sources := []func(context.Context) ([]Device, error){}
+	if config.C.AdvSearchID > 0 {
+		sources = append(sources, GetAdvSearchDevices)
+	}
+	if len(config.C.ComputerGroupIDs) > 0 {
+		sources = append(sources, func(ctx context.Context) ([]Device, error) {
+			return GetGroupDevices(ctx, config.C.ComputerGroupIDs)
+		})
+	}
+	if config.C.MobileSearchID > 0 {
+		sources = append(sources, GetMobileSearchDevices)
+	}
+	if len(config.C.MobileDeviceGroupIDs) > 0 {
+		sources = append(sources, func(ctx context.Context) ([]Device, error) {
+			return GetMobileGroupDevices(ctx, config.C.MobileDeviceGroupIDs)
+		})
+	}
+	devices := []Device{}
+	for _, source := range sources {
+		found, err := source(ctx)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, found...)
+	}
+	return devices, nil
+}
+
+// GetDesired returns every username that should have a license, merging
+// the users of computers and mobile devices, along with the devices
+// that justify each one. It returns ErrNoResults rather than an empty
+// list.
+func GetDesired(ctx context.Context) ([]string, map[string][]Device, error) {
+	devices, err := GetDevices(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	usernames := make([]string, len(devices))
+	for i, j := range devices {
+		usernames[i] = j.Username
+	}
+	uids, err := resolveNames(ctx, usernames)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := unique(usernames, uids)
+	observeDevices(devices, names)
+	if len(names) == 0 {
+		return nil, nil, ErrNoResults
+	}
+	return names, devicesByUser(devices, uids), nil
+}
+
+// observeDevices sets the result size gauges for a sync
+func observeDevices(devices []Device, names []string) {
+	kinds := map[string]int{KindComputer: 0, KindMobile: 0}
+	for _, j := range devices {
+		kinds[j.Kind]++
+	}
+	for kind, n := range kinds {
+		desiredDevices.With(prometheus.Labels{"kind": kind}).Set(float64(n))
+	}
+	desiredUsers.Set(float64(len(names)))
+}
+
+// devicesByUser groups devices by the uid their username maps to
+func devicesByUser(devices []Device, uids map[string]string) map[string][]Device {
+	sources := make(map[string][]Device)
+	for _, j := range devices {
+		if name, ok := uids[j.Username]; ok {
+			sources[name] = append(sources[name], j)
+		}
+	}
+	return sources
+}
+
+// licenseSources flattens the devices for each user into rows for the
+// license_sources table
+func licenseSources(sources map[string][]Device) []data.LicenseSource {
+	rows := []data.LicenseSource{}
+	for name, devices := range sources {
+		for _, j := range devices {
+			rows = append(rows, data.LicenseSource{
+				UniqueID:     name,
+				Kind:         j.Kind,
+				DeviceID:     j.ID,
+				DeviceName:   j.Name,
+				SerialNumber: j.SerialNumber,
+				Source:       j.Source,
+			})
+		}
+	}
+	return rows
+}
@@ -0,0 +1,92 @@
+package jamf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrNoResults is returned when the JSS reports no users at all, which
+// is far more likely to be a broken search or group than a real answer.
+// Taking it at face value would remove every license.
+var ErrNoResults = errors.New("jamf: the JSS returned no users")
+
+// AuthError is returned when the JSS rejects Mudwork's credentials or
+// they lack a privilege (401 or 403)
+type AuthError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("jamf: %s was refused with %d, check the JSS credentials and privileges", e.Path, e.StatusCode)
+}
+
+// NotFoundError is returned when a search, group or endpoint doesn't
+// exist (404)
+type NotFoundError struct {
+	Path string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("jamf: %s was not found", e.Path)
+}
+
+// ServerError is returned when the JSS fails or is overloaded (5xx or
+// 429). It is the only status worth retrying.
+type ServerError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("jamf: %s failed with %d", e.Path, e.StatusCode)
+}
+
+// StatusError is returned for any other response that isn't 200
+type StatusError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("jamf: %s returned %d", e.Path, e.StatusCode)
+}
+
+// checkStatus returns the typed error for resp, or nil for a 200
+func checkStatus(path string, resp *http.Response) error {
+	switch code := resp.StatusCode; {
+	case code == http.StatusOK:
+		return nil
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return &AuthError{path, code}
+	case code == http.StatusNotFound:
+		return &NotFoundError{path}
+	case code == http.StatusTooManyRequests || code >= 500:
+		return &ServerError{path, code}
+	default:
+		return &StatusError{path, code}
+	}
+}
+
+// transient reports whether err may go away on its own: a server error
+// or a network failure
+func transient(err error) bool {
+	if _, ok := err.(*ServerError); ok {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// maxAttempts is the number of times a transient failure is tried
+const maxAttempts = 4
+
+var sleep = time.Sleep
+
+// backoff is the wait after a failed attempt: 2s, 4s, 8s
+func backoff(attempt int) time.Duration {
+	return time.Second * 2 << uint(attempt-1)
+}
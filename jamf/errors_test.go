@@ -0,0 +1,81 @@
+package jamf
+
+import (
+	"context"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetStatus(t *testing.T) {
+	var failures, waits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if failures < 2 {
+				failures++
+				w.WriteHeader(http.StatusBadGateway)
+				fmt.Fprint(w, "<html>Bad Gateway</html>")
+				return
+			}
+			fmt.Fprint(w, "<ok/>")
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/denied":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/JSSResource/advancedcomputersearches/id/26":
+			fmt.Fprint(w, "<advanced_computer_search><computers/></advanced_computer_search>")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	saved, savedAuth, savedSleep := config.C, Auth, sleep
+	defer func() { config.C, Auth, sleep = saved, savedAuth, savedSleep }()
+	config.C.JssUrl = ts.URL
+	Auth = NewSession(AuthBasic)
+	sleep = func(time.Duration) { waits++ }
+
+	if body, err := get(context.Background(), "/flaky"); err != nil || string(body) != "<ok/>" || waits != 2 {
+		t.Errorf("got %q, %v after %d waits, wanted success on the third attempt\n", body, err, waits)
+	}
+	waits = 0
+	if _, err := get(context.Background(), "/down"); err == nil || waits != maxAttempts-1 {
+		t.Errorf("got %v after %d waits, wanted a ServerError after %d attempts\n", err, waits, maxAttempts)
+	} else if _, ok := err.(*ServerError); !ok {
+		t.Errorf("got %T, wanted *ServerError\n", err)
+	}
+	waits = 0
+	if _, err := get(context.Background(), "/denied"); waits != 0 {
+		t.Errorf("waited %d times, wanted no retries for an auth failure\n", waits)
+	} else if _, ok := err.(*AuthError); !ok {
+		t.Errorf("got %T, wanted *AuthError\n", err)
+	}
+	if _, err := get(context.Background(), "/missing"); err == nil {
+		t.Error("got no error for a 404")
+	} else if _, ok := err.(*NotFoundError); !ok {
+		t.Errorf("got %T, wanted *NotFoundError\n", err)
+	}
+
+	config.C.AdvSearchID, config.C.ComputerGroupIDs = 26, nil
+	config.C.MobileSearchID, config.C.MobileDeviceGroupIDs = 0, nil
+	if _, err := GetDesiredNames(context.Background()); err != ErrNoResults {
+		t.Errorf("got %v, wanted ErrNoResults for an empty search\n", err)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"/JSSResource/advancedcomputersearches/id/12":                         "/JSSResource/advancedcomputersearches/id/:id",
+		"/api/v1/computers-inventory-detail/10":                               "/api/v1/computers-inventory-detail/:id",
+		"/api/v1/computers-inventory?section=USER_AND_LOCATION&page-size=100": "/api/v1/computers-inventory",
+	}
+	for path, want := range tests {
+		if got := endpoint(path); got != want {
+			t.Errorf("endpoint(%q) = %q, wanted %q\n", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package jamf
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/logging"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// GracePeriod is how long a user may be missing from the JSS before
+// their license is removed. Zero removes licenses straight away.
+func GracePeriod() time.Duration {
+	return time.Duration(config.C.RemovalGracePeriod) * time.Minute
+}
+
+// deferRemovals marks the users missing from the JSS as pending removal
+// and forgets any pending user who is back in desired. It returns the
+// number of users waiting out the grace period.
+func deferRemovals(missing, desired []string, now time.Time) (int, error) {
+	if err := data.MarkPendingRemoval(missing, now); err != nil {
+		return 0, err
+	}
+	present := make(map[string]bool)
+	for _, j := range desired {
+		present[j] = true
+	}
+	back := []string{}
+	pending := data.GetPendingRemovals()
+	for uid := range pending {
+		if present[uid] {
+			back = append(back, uid)
+		}
+	}
+	if err := data.ClearPendingRemoval(back); err != nil {
+		return 0, err
+	}
+	return len(pending) - len(back), nil
+}
+
+// QueueExpiredRemovals queues a remove for each user who has been
+// missing from the JSS for longer than the grace period, and returns
+// how many were queued
+func QueueExpiredRemovals(ctx context.Context, now time.Time) int {
+	licensed := make(map[string]bool)
+	for _, j := range data.GetUsers() {
+		licensed[j] = true
+	}
+	queued := data.GetQueued()
+	cutoff := now.Add(-GracePeriod())
+	expired, entries := []string{}, []data.TxEntry{}
+	for uid, since := range data.GetPendingRemovals() {
+		if since.After(cutoff) {
+			continue
+		}
+		expired = append(expired, uid)
+		entry := data.TxEntry{UniqueID: uid, TxType: "remove"}
+		if licensed[uid] && !queued[entry] {
+			entries = append(entries, entry)
+		}
+	}
+	if len(expired) == 0 {
+		return 0
+	}
+	if err := data.InsertTxEntries(entries); err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"table": "txlog",
+			"error": err,
+		}).Warn("Could not queue expired removals")
+		return 0
+	}
+	if err := data.ClearPendingRemoval(expired); err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"table": "pending_removal",
+			"error": err,
+		}).Warn("Could not clear pending removals")
+	}
+	if len(entries) > 0 {
+		logging.From(ctx).WithFields(log.Fields{
+			"remove_queued": len(entries),
+			"grace_period":  GracePeriod(),
+		}).Info("Grace period over, removals queued")
+	}
+	return len(entries)
+}
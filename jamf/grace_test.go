@@ -0,0 +1,58 @@
+package jamf
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"testing"
+	"time"
+)
+
+func TestGracePeriod(t *testing.T) {
+	saved := config.C
+	defer func() { config.C = saved }()
+	config.C.RemovalGracePeriod = 60
+	users := []string{"gracegone", "graceback"}
+	for _, j := range users {
+		data.InsertUser(j)
+	}
+	defer func() {
+		for _, j := range users {
+			data.DeleteUser(j)
+			data.DeleteTxEntry(&data.TxEntry{UniqueID: j, TxType: "remove"})
+		}
+		data.ClearPendingRemoval(users)
+	}()
+	start := time.Now()
+
+	pending, err := deferRemovals(users, []string{}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 2 {
+		t.Errorf("got %d pending, wanted 2\n", pending)
+	}
+	if queued := QueueExpiredRemovals(context.Background(), start.Add(30*time.Minute)); queued != 0 {
+		t.Errorf("queued %d removals inside the grace period\n", queued)
+	}
+	// graceback reappears, and marking gracegone again keeps its first time
+	pending, err = deferRemovals([]string{"gracegone"}, []string{"graceback"}, start.Add(45*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 {
+		t.Errorf("got %d pending, wanted 1\n", pending)
+	}
+	if queued := QueueExpiredRemovals(context.Background(), start.Add(61*time.Minute)); queued != 1 {
+		t.Errorf("queued %d removals, wanted 1\n", queued)
+	}
+	if !data.LookupTxEntry(&data.TxEntry{UniqueID: "gracegone", TxType: "remove"}) {
+		t.Error("gracegone was not queued for removal")
+	}
+	if data.LookupTxEntry(&data.TxEntry{UniqueID: "graceback", TxType: "remove"}) {
+		t.Error("graceback was queued for removal")
+	}
+	if _, ok := data.GetPendingRemovals()["gracegone"]; ok {
+		t.Error("gracegone is still pending after being queued")
+	}
+}
@@ -0,0 +1,149 @@
+package jamf
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ComputerGroup is a static or smart computer group from the Classic
+// API. Its members don't carry their usernames.
+type ComputerGroup struct {
+	XMLName   xml.Name        `xml:"computer_group"`
+	ID        int             `xml:"id"`
+	Name      string          `xml:"name"`
+	IsSmart   bool            `xml:"is_smart"`
+	Computers []GroupComputer `xml:"computers>computer"`
+}
+
+// GroupComputer is a member of a ComputerGroup
+type GroupComputer struct {
+	ID           int    `xml:"id"`
+	Name         string `xml:"name"`
+	SerialNumber string `xml:"serial_number"`
+}
+
+// inventoryPage is a page of computer or mobile device inventory with
+// only the user and location section
+type inventoryPage struct {
+	TotalCount int `json:"totalCount"`
+	Results    []struct {
+		ID              string `json:"id"`
+		MobileDeviceID  string `json:"mobileDeviceId"`
+		UserAndLocation struct {
+			Username string `json:"username"`
+		} `json:"userAndLocation"`
+	} `json:"results"`
+}
+
+// inventoryBatch is the number of devices asked for in each inventory
+// request
+const inventoryBatch = 100
+
+// GetComputerGroup returns the group with id and its members
+func GetComputerGroup(ctx context.Context, id int) (*ComputerGroup, error) {
+	body, err := get(ctx, fmt.Sprintf("/JSSResource/computergroups/id/%d", id))
+	if err != nil {
+		return nil, err
+	}
+	group := &ComputerGroup{}
+	if err := xml.Unmarshal(body, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// GetGroupDevices returns the computers in each of the groups in ids.
+// Their usernames are read from the inventory in batches, and a
+// computer in several groups is looked up once.
+func GetGroupDevices(ctx context.Context, ids []int) ([]Device, error) {
+	devices := []Device{}
+	for _, id := range ids {
+		group, err := GetComputerGroup(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("jamf: computer group %d: %v", id, err)
+		}
+		for _, j := range group.Computers {
+			devices = append(devices, Device{
+				Kind:         KindComputer,
+				ID:           j.ID,
+				Name:         j.Name,
+				SerialNumber: j.SerialNumber,
+				Source:       "computer group " + group.Name,
+			})
+		}
+	}
+	return devices, setUsernames(ctx, devices, GetUsernames)
+}
+
+// setUsernames fills in the Username of each device from lookup
+func setUsernames(ctx context.Context, devices []Device, lookup func(context.Context, []int) (map[int]string, error)) error {
+	seen := make(map[int]bool)
+	ids := []int{}
+	for _, j := range devices {
+		if !seen[j.ID] {
+			seen[j.ID] = true
+			ids = append(ids, j.ID)
+		}
+	}
+	usernames, err := lookup(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range devices {
+		devices[i].Username = usernames[devices[i].ID]
+	}
+	return nil
+}
+
+// GetUsernames returns the username assigned to each computer in ids,
+// keyed by computer ID
+func GetUsernames(ctx context.Context, ids []int) (map[int]string, error) {
+	return inventoryUsernames(ctx, "/api/v1/computers-inventory", "id", ids)
+}
+
+// inventoryUsernames reads the usernames of the devices in ids from the
+// inventory at path, filtering on idField
+func inventoryUsernames(ctx context.Context, path, idField string, ids []int) (map[int]string, error) {
+	usernames := make(map[int]string)
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > inventoryBatch {
+			n = inventoryBatch
+		}
+		batch := make([]string, n)
+		for i, j := range ids[:n] {
+			batch[i] = strconv.Itoa(j)
+		}
+		ids = ids[n:]
+		query := url.Values{}
+		query.Set("section", "USER_AND_LOCATION")
+		query.Set("page", "0")
+		query.Set("page-size", strconv.Itoa(inventoryBatch))
+		query.Set("filter", fmt.Sprintf("%s=in=(%s)", idField, strings.Join(batch, ",")))
+		body, err := get(ctx, path+"?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		page := inventoryPage{}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, j := range page.Results {
+			value := j.ID
+			if j.MobileDeviceID != "" {
+				value = j.MobileDeviceID
+			}
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("jamf: invalid device id %q in inventory", value)
+			}
+			usernames[id] = j.UserAndLocation.Username
+		}
+	}
+	return usernames, nil
+}
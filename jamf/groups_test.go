@@ -0,0 +1,126 @@
+package jamf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetGroupDevices(t *testing.T) {
+	var inventoryRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/token":
+			json.NewEncoder(w).Encode(tokenResponse{Token: "t1", Expires: time.Now().Add(time.Hour)})
+		case "/JSSResource/computergroups/id/1":
+			fmt.Fprint(w, `<computer_group><id>1</id><name>Lab</name><is_smart>true</is_smart><computers>
+				<computer><id>10</id><name>lab-01</name><serial_number>C02A</serial_number></computer>
+				<computer><id>11</id><name>lab-02</name><serial_number>C02B</serial_number></computer>
+				</computers></computer_group>`)
+		case "/JSSResource/computergroups/id/2":
+			fmt.Fprint(w, `<computer_group><id>2</id><name>Staff</name><computers>
+				<computer><id>11</id><name>lab-02</name></computer>
+				<computer><id>12</id><name>office</name></computer>
+				</computers></computer_group>`)
+		case "/api/v1/computers-inventory":
+			inventoryRequests++
+			if r.Header.Get("Authorization") != "Bearer t1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if got := r.URL.Query().Get("filter"); got != "id=in=(10,11,12)" {
+				t.Errorf("got filter %s\n", got)
+			}
+			fmt.Fprint(w, `{"totalCount": 3, "results": [
+				{"id": "10", "userAndLocation": {"username": "JDoe"}},
+				{"id": "11", "userAndLocation": {"username": "asmith"}},
+				{"id": "12", "userAndLocation": {"username": "jdoe"}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	saved, savedAuth, savedUsernames := config.C, Auth, Usernames
+	defer func() { config.C, Auth, Usernames = saved, savedAuth, savedUsernames }()
+	config.C.JssUrl = ts.URL
+	Auth = NewSession(AuthBasic)
+	// JDoe and jdoe are the same user once folded
+	Usernames = &UsernamePolicy{MinLength: 2, Lowercase: true}
+
+	devices, err := GetGroupDevices(context.Background(), []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 4 {
+		t.Fatalf("got %d devices, wanted one for each group membership\n", len(devices))
+	}
+	usernames := make([]string, len(devices))
+	for i, j := range devices {
+		usernames[i] = j.Username
+	}
+	uids, err := resolveNames(context.Background(), usernames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := devicesByUser(devices, uids)
+	if got := sources["jdoe"]; len(got) != 2 || got[0].SerialNumber != "C02A" || got[1].Name != "office" {
+		t.Errorf("got %+v for jdoe, wanted lab-01 and office\n", got)
+	}
+	if got := sources["asmith"]; len(got) != 2 || got[0].Source != "computer group Lab" || got[1].Source != "computer group Staff" {
+		t.Errorf("got %+v for asmith, wanted lab-02 through both groups\n", got)
+	}
+	if inventoryRequests != 1 {
+		t.Errorf("made %d inventory requests, wanted 1\n", inventoryRequests)
+	}
+}
+
+func TestGetDesiredNames(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/auth/token":
+			json.NewEncoder(w).Encode(tokenResponse{Token: "t1", Expires: time.Now().Add(time.Hour)})
+		case "/JSSResource/advancedcomputersearches/id/26":
+			fmt.Fprint(w, `<advanced_computer_search><computers>
+				<computer><Username>jdoe</Username></computer>
+				</computers></advanced_computer_search>`)
+		case "/JSSResource/advancedmobiledevicesearches/id/7":
+			fmt.Fprint(w, `<advanced_mobile_device_search><mobile_devices>
+				<mobile_device><Username>jdoe</Username></mobile_device>
+				<mobile_device><Username>cart-user</Username></mobile_device>
+				</mobile_devices></advanced_mobile_device_search>`)
+		case "/JSSResource/mobiledevicegroups/id/3":
+			fmt.Fprint(w, `<mobile_device_group><id>3</id><name>iPad Cart</name><mobile_devices>
+				<mobile_device><id>40</id><name>ipad-40</name></mobile_device>
+				</mobile_devices></mobile_device_group>`)
+		case "/api/v2/mobile-devices/detail":
+			if got := r.URL.Query().Get("filter"); got != "mobileDeviceId=in=(40)" {
+				t.Errorf("got filter %s\n", got)
+			}
+			fmt.Fprint(w, `{"totalCount": 1, "results": [
+				{"mobileDeviceId": "40", "userAndLocation": {"username": "asmith"}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	saved, savedAuth := config.C, Auth
+	defer func() { config.C, Auth = saved, savedAuth }()
+	config.C.JssUrl = ts.URL
+	config.C.AdvSearchID, config.C.ComputerGroupIDs = 26, nil
+	config.C.MobileSearchID, config.C.MobileDeviceGroupIDs = 7, []int{3}
+	Auth = NewSession(AuthBasic)
+
+	names, err := GetDesiredNames(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, ","); got != "jdoe,cart-user,asmith" {
+		t.Errorf("got %s, wanted jdoe,cart-user,asmith\n", got)
+	}
+}
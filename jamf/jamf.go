@@ -1,15 +1,23 @@
 package jamf
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"github.com/cosmouser/mudwork/config"
 	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/logging"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/cosmouser/mudwork/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,8 +44,13 @@ type AdvSearch struct {
 	Computers []Computer `xml:"computers>computer"`
 }
 
+// Computer is a row of an AdvSearch. The search must display Username,
+// and Serial_Number if serials should be recorded.
 type Computer struct {
-	Username string `xml:"Username"`
+	ID           int    `xml:"id"`
+	Name         string `xml:"name"`
+	SerialNumber string `xml:"Serial_Number"`
+	Username     string `xml:"Username"`
 }
 
 var (
@@ -47,6 +60,56 @@ var (
 			Help: "Total number of errors when requesting search results from the JSS.",
 		},
 	)
+	webhooksReceived = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mudwork_webhooks_received_total",
+			Help: "Total number of webhooks received from the JSS.",
+		},
+	)
+	webhooksRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mudwork_webhooks_rejected_total",
+			Help: "Total number of webhooks ignored, by reason.",
+		},
+		[]string{"reason"},
+	)
+	syncDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mudwork_sync_duration_seconds",
+			Help:    "Time taken to read the JSS searches and groups and queue the changes.",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+		},
+		[]string{"result"},
+	)
+	fetchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mudwork_jamf_fetch_duration_seconds",
+			Help:    "Time taken by each request to the JSS.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+	fetchSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mudwork_jamf_fetch_response_bytes",
+			Help:    "Size of each successful response from the JSS.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+		},
+		[]string{"endpoint"},
+	)
+	desiredDevices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mudwork_jamf_devices",
+			Help: "Number of devices found by the last sync, by kind.",
+		},
+		[]string{"kind"},
+	)
+	desiredUsers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mudwork_jamf_desired_users",
+			Help: "Number of users the last sync found should have a license.",
+		},
+	)
 )
 
 // WebhookHandler is the http server handler for incoming Jamf Webhooks
@@ -64,15 +127,28 @@ func MakeWebhookHandler(messenger chan int) func(http.ResponseWriter, *http.Requ
 			w.Write([]byte("mudwork"))
 		case "POST":
 			defer r.Body.Close()
+			webhooksReceived.Inc()
+			ctx, span := tracing.Start(r.Context(), "webhook")
+			defer span.End()
+			// every line logged for this webhook and the sync it starts
+			// carries the same correlation_id
+			ctx = logging.WithCorrelation(ctx)
 			err := json.NewDecoder(r.Body).Decode(&jamfWebhook)
 			if err != nil {
-				log.WithFields(log.Fields{
+				webhooksRejected.With(prometheus.Labels{"reason": "invalid_body"}).Inc()
+				span.SetAttributes(attribute.String("webhook.rejected", "invalid_body"))
+				logging.From(ctx).WithFields(log.Fields{
 					"xrealip": r.Header.Get("X-Real-IP"),
 				}).Warn(err)
 				return
 			}
+			span.SetAttributes(
+				attribute.Int("webhook.id", jamfWebhook.Webhook.ID),
+				attribute.String("webhook.event", jamfWebhook.Webhook.WebhookEvent))
 			if jamfUser := jamfWebhook.Event.AuthorizedUsername; jamfUser != config.C.CirrupUser {
-				//		log.WithFields(log.Fields{
+				webhooksRejected.With(prometheus.Labels{"reason": "unknown_user"}).Inc()
+				span.SetAttributes(attribute.String("webhook.rejected", "unknown_user"))
+				//		logging.From(ctx).WithFields(log.Fields{
 				//			"webhook_id": jamfWebhook.Webhook.ID,
 				//			"jamf_user":  jamfUser,
 				//			"xrealip":    r.Header.Get("X-Real-IP"),
@@ -82,127 +158,208 @@ func MakeWebhookHandler(messenger chan int) func(http.ResponseWriter, *http.Requ
 			// So far, we've confirmed with some certainty that the request
 			// is from the JSS and is a POST in RestAPIOperation webhook
 			// format and that it is from Cirrup.
-			// Now, Mudwork should query its advanced search at the JSS for
-			// a snapshot of the current list of users that should be given
-			// entitlements.
+			// Now, Mudwork should query its advanced search and computer
+			// groups at the JSS for a snapshot of the current list of
+			// users that should be given entitlements.
+			ctx, syncSpan := tracing.Start(ctx, "sync")
+			start, result := time.Now(), "error"
+			var syncErr error
+			defer func() {
+				syncDuration.With(prometheus.Labels{"result": result}).Observe(time.Since(start).Seconds())
+				tracing.End(syncSpan, syncErr)
+			}()
 
-			// Add an incremental backoff when errors received. Fail after a number of tries
-			var gasnRetries int
-			names, err := GetAdvSearchNames()
+			names, sources, err := GetDesired(ctx)
 			if err != nil {
-				log.WithFields(log.Fields{
-					"function": "GetAdvSearchNames",
+				syncErr = err
+				// transient failures have already been retried, and
+				// without a trustworthy list nothing can be queued
+				logging.From(ctx).WithFields(log.Fields{
+					"function": "GetDesiredNames",
 					"error":    err,
-				}).Error("Unable to unmarshal response from JSS")
+				}).Error("Unable to get users from JSS")
 				advSearchErrors.Inc()
-				for err != nil {
-					gasnRetries++
-					names, err = GetAdvSearchNames()
-					if err != nil {
-						log.WithFields(log.Fields{
-							"function": "GetAdvSearchNames",
-							"error":    err,
-						}).Error("Unable to unmarshal response from JSS")
-						advSearchErrors.Inc()
-						// fail after a number of retries
-						if gasnRetries > 5 {
-							return
-						}
-						time.Sleep(time.Second * 4 * time.Duration(gasnRetries))
+				return
+			}
+			if err := data.ReplaceLicenseSources(licenseSources(sources)); err != nil {
+				logging.From(ctx).WithFields(log.Fields{
+					"table": "license_sources",
+					"error": err,
+				}).Warn("Could not record license sources")
+			}
+			changes := data.DiffSets(names, data.GetUsers())
+			// check the whole diff against the txlog and the review
+			// table with one query each
+			queued := data.GetQueued()
+			held := make(map[string]bool)
+			for _, status := range []string{data.ReviewPending, data.ReviewQuota} {
+				for _, r := range data.GetReviews(status) {
+					if r.TxType == "add" {
+						held[r.UniqueID] = true
 					}
 				}
 			}
-			users := data.GetUsers()
-			add := data.Diff(names, users)
-			remove := data.Diff(users, names)
+			entries := []data.TxEntry{}
 			var queuedAdd, queuedRemove, dupAdd, dupRemove int
-
-			for _, j := range add {
-				// filter out usernames less than 2 characters long
-				if len(j) < 2 {
+			for _, j := range changes.Add {
+				// users held for review wait for an administrator, and
+				// users held for the quota wait for a free seat
+				if held[j] {
 					continue
 				}
-				entry := &data.TxEntry{j, "add"}
-				if inTxlog := data.LookupTxEntry(entry); !inTxlog {
-					err := data.InsertTxEntry(entry)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"user":   entry.UniqueID,
-							"method": "add",
-							"table":  "txlog",
-						}).Warn("Could not insert user")
-					} else {
-						queuedAdd++
-					}
-				} else {
+				entry := data.TxEntry{UniqueID: j, TxType: "add"}
+				if queued[entry] {
 					dupAdd++
+					continue
 				}
+				entries = append(entries, entry)
+				queuedAdd++
 			}
-			for _, j := range remove {
-				// filter out usernames less than 2 characters long
-				if len(j) < 2 {
-					continue
+			var pendingRemove int
+			if GracePeriod() > 0 {
+				// missing users keep their license until they have been
+				// gone for the grace period
+				pendingRemove, err = deferRemovals(changes.Remove, names, time.Now())
+				if err != nil {
+					logging.From(ctx).WithFields(log.Fields{
+						"table": "pending_removal",
+						"error": err,
+					}).Warn("Could not record pending removals")
 				}
-				entry := &data.TxEntry{j, "remove"}
-				if inTxlog := data.LookupTxEntry(entry); !inTxlog {
-					err := data.InsertTxEntry(entry)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"user":   entry.UniqueID,
-							"method": "remove",
-							"table":  "txlog",
-						}).Warn("Could not insert user")
-					} else {
-						queuedRemove++
+			} else {
+				for _, j := range changes.Remove {
+					entry := data.TxEntry{UniqueID: j, TxType: "remove"}
+					if queued[entry] {
+						dupRemove++
+						continue
 					}
-				} else {
-					dupRemove++
+					entries = append(entries, entry)
+					queuedRemove++
 				}
 			}
+			if err := data.InsertTxEntries(entries); err != nil {
+				logging.From(ctx).WithFields(log.Fields{
+					"add":    queuedAdd,
+					"remove": queuedRemove,
+					"table":  "txlog",
+					"error":  err,
+				}).Warn("Could not queue users")
+				queuedAdd, queuedRemove = 0, 0
+			}
+			if GracePeriod() > 0 {
+				queuedRemove += QueueExpiredRemovals(ctx, time.Now())
+			}
 			numChanges := queuedAdd + queuedRemove + dupAdd + dupRemove
-			log.WithFields(log.Fields{
-				"total":         numChanges,
-				"add_queued":    queuedAdd,
-				"remove_queued": queuedRemove,
-				"dup_add":       dupAdd,
-				"dup_remove":    dupRemove,
+			logging.From(ctx).WithFields(log.Fields{
+				"total":          numChanges,
+				"add_queued":     queuedAdd,
+				"remove_queued":  queuedRemove,
+				"dup_add":        dupAdd,
+				"dup_remove":     dupRemove,
+				"remove_pending": pendingRemove,
+				"unchanged":      len(changes.Unchanged),
+				"rejected":       len(Rejected()),
 			}).Info("Search parsed")
+			syncSpan.SetAttributes(
+				attribute.Int("sync.users", len(names)),
+				attribute.Int("sync.add_queued", queuedAdd),
+				attribute.Int("sync.remove_queued", queuedRemove),
+				attribute.Int("sync.remove_pending", pendingRemove),
+				attribute.Int("sync.unchanged", len(changes.Unchanged)),
+				attribute.Int("sync.rejected", len(Rejected())))
+			result = "ok"
 			if numChanges > 0 {
-				messenger <- numChanges
+				// don't hold up the JSS while the worker is busy; it
+				// picks up everything queued when it next runs
+				select {
+				case messenger <- numChanges:
+				default:
+				}
 			}
+		default:
+			webhooksRejected.With(prometheus.Labels{"reason": "method"}).Inc()
+			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	}
 }
 
-func GetNames(computers []Computer) []string {
+// GetNames returns each unique uid the Usernames policy maps the
+// computers' usernames to. Computers without a username are skipped;
+// any other rejected name is logged and reported by Rejected.
+func GetNames(ctx context.Context, computers []Computer) ([]string, error) {
+	usernames := make([]string, len(computers))
+	for i, j := range computers {
+		usernames[i] = j.Username
+	}
+	uids, err := resolveNames(ctx, usernames)
+	if err != nil {
+		return nil, err
+	}
+	return unique(usernames, uids), nil
+}
+
+// unique returns the uid of each accepted username once, in order
+func unique(usernames []string, uids map[string]string) []string {
 	result := []string{}
 	names := make(map[string]bool)
-	for _, j := range computers {
-		if _, ok := names[j.Username]; !ok {
-			result = append(result, j.Username)
-			names[j.Username] = true
+	for _, j := range usernames {
+		name, ok := uids[j]
+		if !ok {
+			continue
+		}
+		if _, ok := names[name]; !ok {
+			result = append(result, name)
+			names[name] = true
 		}
 	}
 	return result
 }
 
-func GetAdvSearchNames() ([]string, error) {
-	result := AdvSearch{}
-	resourceURI := fmt.Sprintf("%s/JSSResource/advancedcomputersearches/id/%d",
-		config.C.JssUrl,
-		config.C.AdvSearchID,
-	)
-	var client = &http.Client{
-		Timeout: time.Second * 10,
-	}
-	req, err := http.NewRequest("GET", resourceURI, nil)
-	req.SetBasicAuth(config.C.ApiUser, config.C.ApiPass)
-	resp, err := client.Do(req)
+// resolveNames maps usernames to uids through the Usernames policy,
+// counting every rejection and logging each rejected name once
+func resolveNames(ctx context.Context, usernames []string) (map[string]string, error) {
+	uids, rejectedNames, err := Usernames.Resolve(ctx, usernames)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	xmlData, err := ioutil.ReadAll(resp.Body)
+	report := []RejectedName{}
+	reported := make(map[string]bool)
+	for _, j := range usernames {
+		reason, ok := rejectedNames[j]
+		if !ok {
+			continue
+		}
+		usernamesRejected.With(prometheus.Labels{"reason": reason}).Inc()
+		if reason == "empty" || reported[j] {
+			continue
+		}
+		reported[j] = true
+		report = append(report, RejectedName{Username: j, Reason: reason})
+		logging.From(ctx).WithFields(log.Fields{
+			"username": j,
+			"reason":   reason,
+		}).Warn("Rejected username from JSS")
+	}
+	rejected.Lock()
+	rejected.names = report
+	rejected.Unlock()
+	return uids, nil
+}
+
+// GetDesiredNames returns every username that should have a license,
+// merging the users of computers and mobile devices from the advanced
+// searches and groups in the config. It returns ErrNoResults rather than
+// an empty list.
+func GetDesiredNames(ctx context.Context) ([]string, error) {
+	names, _, err := GetDesired(ctx)
+	return names, err
+}
+
+// GetAdvSearchDevices returns the computers in the advanced search
+// AdvSearchID
+func GetAdvSearchDevices(ctx context.Context) ([]Device, error) {
+	result := AdvSearch{}
+	xmlData, err := get(ctx, fmt.Sprintf("/JSSResource/advancedcomputersearches/id/%d", config.C.AdvSearchID))
 	if err != nil {
 		return nil, err
 	}
@@ -210,9 +367,102 @@ func GetAdvSearchNames() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	names := GetNames(result.Computers)
-	return names, nil
+	source := fmt.Sprintf("advanced computer search %d", config.C.AdvSearchID)
+	devices := make([]Device, len(result.Computers))
+	for i, j := range result.Computers {
+		devices[i] = Device{
+			Kind:         KindComputer,
+			ID:           j.ID,
+			Name:         j.Name,
+			SerialNumber: j.SerialNumber,
+			Username:     j.Username,
+			Source:       source,
+		}
+	}
+	return devices, nil
+}
+
+// get returns the body of a 200 response for path on the JSS
+func get(ctx context.Context, path string) ([]byte, error) {
+	return do(ctx, "GET", path, nil)
+}
+
+// do sends a request to the JSS and returns the body of a 200 response.
+// Other responses are returned as typed errors, and server and network
+// failures are tried up to maxAttempts times with backoff.
+func do(ctx context.Context, method, path string, body []byte) (output []byte, err error) {
+	ctx, span := tracing.Start(ctx, "jamf.request",
+		attribute.String("http.method", method),
+		attribute.String("jamf.endpoint", endpoint(path)))
+	defer func() { tracing.End(span, err) }()
+	for attempt := 1; ; attempt++ {
+		span.SetAttributes(attribute.Int("jamf.attempts", attempt))
+		output, err = fetch(ctx, method, path, body)
+		if err == nil || !transient(err) || attempt == maxAttempts {
+			return output, err
+		}
+		wait := backoff(attempt)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("wait", wait.String()),
+			attribute.String("error", err.Error())))
+		logging.From(ctx).WithFields(log.Fields{
+			"method":  method,
+			"path":    path,
+			"attempt": attempt,
+			"wait":    wait,
+			"error":   err,
+		}).Warn("Retrying request to JSS")
+		sleep(wait)
+	}
+}
+
+func fetch(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	name := endpoint(path)
+	start := time.Now()
+	defer func() {
+		fetchDuration.With(prometheus.Labels{"endpoint": name}).Observe(time.Since(start).Seconds())
+	}()
+	resp, err := Auth.Do(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if err := checkStatus(path, resp); err != nil {
+		return nil, err
+	}
+	output, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		fetchSize.With(prometheus.Labels{"endpoint": name}).Observe(float64(len(output)))
+		span.SetAttributes(attribute.Int("jamf.response_bytes", len(output)))
+	}
+	return output, err
+}
+
+// endpoint names the JSS endpoint for path in metric labels, without
+// the query or any ids
+func endpoint(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	parts := strings.Split(path, "/")
+	for i, j := range parts {
+		if _, err := strconv.Atoi(j); err == nil {
+			parts[i] = ":id"
+		}
+	}
+	return strings.Join(parts, "/")
 }
+
 func init() {
-	prometheus.MustRegister(advSearchErrors)
+	metrics.MustRegister(advSearchErrors)
+	metrics.MustRegister(webhooksReceived)
+	metrics.MustRegister(webhooksRejected)
+	metrics.MustRegister(syncDuration)
+	metrics.MustRegister(fetchDuration)
+	metrics.MustRegister(fetchSize)
+	metrics.MustRegister(desiredDevices)
+	metrics.MustRegister(desiredUsers)
 }
@@ -0,0 +1,107 @@
+package jamf
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+)
+
+// MobileSearch is an advanced mobile device search displaying Username,
+// and Serial_Number if serials should be recorded
+type MobileSearch struct {
+	XMLName       xml.Name       `xml:"advanced_mobile_device_search"`
+	MobileDevices []MobileDevice `xml:"mobile_devices>mobile_device"`
+}
+
+// MobileDevice is a row of a MobileSearch
+type MobileDevice struct {
+	ID           int    `xml:"id"`
+	Name         string `xml:"name"`
+	SerialNumber string `xml:"Serial_Number"`
+	Username     string `xml:"Username"`
+}
+
+// MobileDeviceGroup is a static or smart mobile device group from the
+// Classic API. Its members don't carry their usernames.
+type MobileDeviceGroup struct {
+	XMLName       xml.Name            `xml:"mobile_device_group"`
+	ID            int                 `xml:"id"`
+	Name          string              `xml:"name"`
+	IsSmart       bool                `xml:"is_smart"`
+	MobileDevices []GroupMobileDevice `xml:"mobile_devices>mobile_device"`
+}
+
+// GroupMobileDevice is a member of a MobileDeviceGroup
+type GroupMobileDevice struct {
+	ID           int    `xml:"id"`
+	Name         string `xml:"name"`
+	SerialNumber string `xml:"serial_number"`
+}
+
+// GetMobileSearchDevices returns the mobile devices in the advanced
+// mobile device search MobileSearchID
+func GetMobileSearchDevices(ctx context.Context) ([]Device, error) {
+	body, err := get(ctx, fmt.Sprintf("/JSSResource/advancedmobiledevicesearches/id/%d", config.C.MobileSearchID))
+	if err != nil {
+		return nil, err
+	}
+	result := MobileSearch{}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	source := fmt.Sprintf("advanced mobile device search %d", config.C.MobileSearchID)
+	devices := make([]Device, len(result.MobileDevices))
+	for i, j := range result.MobileDevices {
+		devices[i] = Device{
+			Kind:         KindMobile,
+			ID:           j.ID,
+			Name:         j.Name,
+			SerialNumber: j.SerialNumber,
+			Username:     j.Username,
+			Source:       source,
+		}
+	}
+	return devices, nil
+}
+
+// GetMobileDeviceGroup returns the group with id and its members
+func GetMobileDeviceGroup(ctx context.Context, id int) (*MobileDeviceGroup, error) {
+	body, err := get(ctx, fmt.Sprintf("/JSSResource/mobiledevicegroups/id/%d", id))
+	if err != nil {
+		return nil, err
+	}
+	group := &MobileDeviceGroup{}
+	if err := xml.Unmarshal(body, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// GetMobileGroupDevices returns the mobile devices in each of the
+// groups in ids
+func GetMobileGroupDevices(ctx context.Context, ids []int) ([]Device, error) {
+	devices := []Device{}
+	for _, id := range ids {
+		group, err := GetMobileDeviceGroup(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("jamf: mobile device group %d: %v", id, err)
+		}
+		for _, j := range group.MobileDevices {
+			devices = append(devices, Device{
+				Kind:         KindMobile,
+				ID:           j.ID,
+				Name:         j.Name,
+				SerialNumber: j.SerialNumber,
+				Source:       "mobile device group " + group.Name,
+			})
+		}
+	}
+	return devices, setUsernames(ctx, devices, GetMobileUsernames)
+}
+
+// GetMobileUsernames returns the username assigned to each mobile
+// device in ids, keyed by mobile device ID
+func GetMobileUsernames(ctx context.Context, ids []int) (map[int]string, error) {
+	return inventoryUsernames(ctx, "/api/v2/mobile-devices/detail", "mobileDeviceId", ids)
+}
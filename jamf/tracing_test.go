@@ -0,0 +1,113 @@
+package jamf
+
+import (
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spanNamed returns the first span called name
+func spanNamed(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+// attr returns the value of key in attrs
+func attr(attrs []attribute.KeyValue, key string) attribute.Value {
+	for _, j := range attrs {
+		if string(j.Key) == key {
+			return j.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestWebhookSpans(t *testing.T) {
+	var searches int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/JSSResource/advancedcomputersearches/id/26" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if searches++; searches == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `<advanced_computer_search><computers>
+			<computer><id>1</id><name>lab-01</name><Username>tracekeep</Username></computer>
+			<computer><id>2</id><name>lab-02</name><Username>tracenew</Username></computer>
+			</computers></advanced_computer_search>`)
+	}))
+	defer ts.Close()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	saved, savedAuth, savedUsernames, savedSleep, savedTracer := config.C, Auth, Usernames, sleep, tracing.Tracer
+	defer func() {
+		config.C, Auth, Usernames, sleep, tracing.Tracer = saved, savedAuth, savedUsernames, savedSleep, savedTracer
+	}()
+	config.C.JssUrl = ts.URL
+	config.C.CirrupUser = "cirrup"
+	config.C.AdvSearchID = 26
+	config.C.ComputerGroupIDs = nil
+	config.C.MobileSearchID = 0
+	config.C.MobileDeviceGroupIDs = nil
+	config.C.RemovalGracePeriod = 0
+	Auth = NewSession(AuthBasic)
+	Usernames = &UsernamePolicy{MinLength: 2, Lowercase: true}
+	sleep = func(time.Duration) {}
+	tracing.Tracer = provider.Tracer("test")
+	data.InsertUser("tracekeep")
+	defer func() {
+		data.DeleteUser("tracekeep")
+		data.DeleteTxEntry(&data.TxEntry{UniqueID: "tracenew", TxType: "add"})
+	}()
+
+	messenger := make(chan int, 1)
+	body := `{"event": {"authorizedUsername": "cirrup"}, "webhook": {"id": 7, "webhookEvent": "RestAPIOperation"}}`
+	MakeWebhookHandler(messenger)(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if got := <-messenger; got != 1 {
+		t.Errorf("got %d changes, wanted 1\n", got)
+	}
+
+	spans := exporter.GetSpans()
+	webhook, sync, request := spanNamed(spans, "webhook"), spanNamed(spans, "sync"), spanNamed(spans, "jamf.request")
+	if webhook == nil || sync == nil || request == nil {
+		t.Fatalf("got %d spans, wanted webhook, sync and jamf.request\n", len(spans))
+	}
+	if attr(webhook.Attributes, "webhook.id").AsInt64() != 7 || attr(webhook.Attributes, "webhook.event").AsString() != "RestAPIOperation" {
+		t.Errorf("got webhook attributes %v\n", webhook.Attributes)
+	}
+	if sync.Parent.SpanID() != webhook.SpanContext.SpanID() {
+		t.Error("sync is not a child of webhook")
+	}
+	for key, want := range map[string]int64{"sync.users": 2, "sync.add_queued": 1, "sync.unchanged": 1} {
+		if got := attr(sync.Attributes, key).AsInt64(); got != want {
+			t.Errorf("got %s %d, wanted %d\n", key, got, want)
+		}
+	}
+	if request.Parent.SpanID() != sync.SpanContext.SpanID() {
+		t.Error("jamf.request is not a child of sync")
+	}
+	if got := attr(request.Attributes, "jamf.attempts").AsInt64(); got != 2 {
+		t.Errorf("got %d attempts, wanted 2\n", got)
+	}
+	if got := attr(request.Attributes, "http.status_code").AsInt64(); got != 200 {
+		t.Errorf("got status %d, wanted 200\n", got)
+	}
+	if len(request.Events) != 1 || request.Events[0].Name != "retry" || attr(request.Events[0].Attributes, "attempt").AsInt64() != 1 {
+		t.Errorf("got events %+v, wanted one retry after the first attempt\n", request.Events)
+	}
+}
@@ -0,0 +1,217 @@
+package jamf
+
+import (
+	"context"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/directory"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// UsernamePolicy validates and normalizes the usernames typed into the
+// User and Location section of each computer record, mapping each one
+// to a single uid
+type UsernamePolicy struct {
+	Pattern      *regexp.Regexp
+	AllowedChars string
+	MinLength    int
+	MaxLength    int
+	Lowercase    bool
+	StripNetbios bool
+	StripDomains []string
+	// Aliases is keyed by the lower case name
+	Aliases      map[string]string
+	ResolveEmail bool
+}
+
+// resolveEmails looks up uids by email for ResolveEmail
+var resolveEmails = directory.GetUidsByEmail
+
+// Usernames is the policy GetNames applies, built from the config
+var Usernames *UsernamePolicy
+
+var (
+	usernamesRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mudwork_usernames_rejected_total",
+			Help: "Total number of usernames from the JSS rejected by the username policy.",
+		},
+		[]string{"reason"},
+	)
+)
+
+// RejectedName is a username from the JSS that couldn't be mapped to a
+// uid, and why
+type RejectedName struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+// rejected holds the names turned away by the last sync
+var rejected struct {
+	sync.Mutex
+	names []RejectedName
+}
+
+// Rejected returns the usernames the last sync couldn't map to a uid,
+// other than empty ones
+func Rejected() []RejectedName {
+	rejected.Lock()
+	defer rejected.Unlock()
+	return append([]RejectedName{}, rejected.names...)
+}
+
+func init() {
+	var err error
+	Usernames, err = NewUsernamePolicy(config.C.Usernames)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"pattern": config.C.Usernames.Pattern,
+		}).Fatal(err)
+	}
+	metrics.MustRegister(usernamesRejected)
+}
+
+// NewUsernamePolicy compiles the policy described by c
+func NewUsernamePolicy(c config.UsernameConfig) (*UsernamePolicy, error) {
+	p := &UsernamePolicy{
+		AllowedChars: c.AllowedChars,
+		MinLength:    2,
+		MaxLength:    c.MaxLength,
+		Lowercase:    c.Lowercase,
+		StripNetbios: c.StripNetbios,
+		StripDomains: c.StripDomains,
+		Aliases:      make(map[string]string),
+		ResolveEmail: c.ResolveEmail,
+	}
+	for k, v := range c.Aliases {
+		if c.Lowercase {
+			v = strings.ToLower(v)
+		}
+		p.Aliases[strings.ToLower(k)] = v
+	}
+	if c.MinLength > 0 {
+		p.MinLength = c.MinLength
+	}
+	if c.Pattern != "" {
+		pattern, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid username pattern: %v", err)
+		}
+		p.Pattern = pattern
+	}
+	return p, nil
+}
+
+// Normalize maps name to a uid as far as it can without the directory:
+// it trims spaces, drops a NetBIOS prefix, folds case, drops a listed
+// domain suffix and applies the alias table, in that order
+func (p *UsernamePolicy) Normalize(name string) string {
+	name = strings.TrimSpace(name)
+	if p.StripNetbios {
+		if i := strings.LastIndex(name, "\\"); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	if p.Lowercase {
+		name = strings.ToLower(name)
+	}
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		for _, j := range p.StripDomains {
+			if strings.EqualFold(name[i+1:], j) {
+				name = name[:i]
+				break
+			}
+		}
+	}
+	if uid, ok := p.Aliases[strings.ToLower(name)]; ok {
+		name = uid
+	}
+	return name
+}
+
+// Check returns the normalized form of name, or a short reason for
+// rejecting it
+func (p *UsernamePolicy) Check(name string) (string, string) {
+	return p.validate(p.Normalize(name))
+}
+
+// Resolve maps each of names to a uid. With ResolveEmail set, names
+// still shaped like an email address after Normalize are looked up in
+// the directory in one go, and those it can't tie to a single user are
+// rejected as "unresolved". It returns the uid for each accepted name
+// and the reason for each rejected one. The error is non-nil only when
+// the directory couldn't be asked.
+func (p *UsernamePolicy) Resolve(ctx context.Context, names []string) (map[string]string, map[string]string, error) {
+	normalized := make(map[string]string)
+	emails := []string{}
+	for _, j := range names {
+		if _, ok := normalized[j]; ok {
+			continue
+		}
+		normalized[j] = p.Normalize(j)
+		if p.ResolveEmail && strings.Contains(normalized[j], "@") {
+			emails = append(emails, normalized[j])
+		}
+	}
+	byEmail := make(map[string]string)
+	if len(emails) > 0 {
+		var err error
+		byEmail, err = resolveEmails(ctx, emails)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	uids := make(map[string]string)
+	rejected := make(map[string]string)
+	for raw, name := range normalized {
+		if p.ResolveEmail && strings.Contains(name, "@") {
+			uid, ok := byEmail[strings.ToLower(name)]
+			if !ok {
+				rejected[raw] = "unresolved"
+				continue
+			}
+			name = uid
+			if p.Lowercase {
+				name = strings.ToLower(name)
+			}
+		}
+		uid, reason := p.validate(name)
+		if reason != "" {
+			rejected[raw] = reason
+			continue
+		}
+		uids[raw] = uid
+	}
+	return uids, rejected, nil
+}
+
+// validate checks a normalized name against the policy
+func (p *UsernamePolicy) validate(name string) (string, string) {
+	length := utf8.RuneCountInString(name)
+	switch {
+	case length == 0:
+		return "", "empty"
+	case length < p.MinLength:
+		return "", "too_short"
+	case p.MaxLength > 0 && length > p.MaxLength:
+		return "", "too_long"
+	}
+	if p.AllowedChars != "" {
+		for _, c := range name {
+			if !strings.ContainsRune(p.AllowedChars, c) {
+				return "", "invalid_char"
+			}
+		}
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(name) {
+		return "", "pattern"
+	}
+	return name, ""
+}
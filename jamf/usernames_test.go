@@ -0,0 +1,105 @@
+package jamf
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"testing"
+)
+
+func TestUsernamePolicy(t *testing.T) {
+	p, err := NewUsernamePolicy(config.UsernameConfig{
+		Pattern:      "^[a-z][a-z0-9]*$",
+		AllowedChars: "abcdefghijklmnopqrstuvwxyz0123456789",
+		MaxLength:    8,
+		Lowercase:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, want, reason string
+	}{
+		{"jdoe", "jdoe", ""},
+		{" JDoe ", "jdoe", ""},
+		{"", "", "empty"},
+		{"j", "", "too_short"},
+		{"jonathandoe", "", "too_long"},
+		{"jdoe*", "", "invalid_char"},
+		{"j)(uid", "", "invalid_char"},
+		{"1jdoe", "", "pattern"},
+	}
+	for _, tt := range tests {
+		got, reason := p.Check(tt.name)
+		if got != tt.want || reason != tt.reason {
+			t.Errorf("Check(%q) = %q, %q, wanted %q, %q\n", tt.name, got, reason, tt.want, tt.reason)
+		}
+	}
+	if _, err := NewUsernamePolicy(config.UsernameConfig{Pattern: "("}); err == nil {
+		t.Error("NewUsernamePolicy accepted an invalid pattern")
+	}
+}
+
+func TestGetNames(t *testing.T) {
+	computers := []Computer{{Username: "jdoe"}, {Username: "JDOE"}, {Username: ""}, {Username: "x"}, {Username: "asmith"}}
+	saved := Usernames
+	defer func() { Usernames = saved }()
+	Usernames = &UsernamePolicy{MinLength: 2, Lowercase: true}
+	got, err := GetNames(context.Background(), computers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"jdoe", "asmith"}
+	if len(got) != len(want) {
+		t.Fatalf("GetNames returned %v, wanted %v\n", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("GetNames returned %v, wanted %v\n", got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	p, err := NewUsernamePolicy(config.UsernameConfig{
+		AllowedChars: "abcdefghijklmnopqrstuvwxyz0123456789.",
+		Lowercase:    true,
+		StripNetbios: true,
+		StripDomains: []string{"uni.edu"},
+		Aliases:      map[string]string{"Jane.Doe": "JDoe"},
+		ResolveEmail: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := resolveEmails
+	defer func() { resolveEmails = saved }()
+	var asked []string
+	resolveEmails = func(ctx context.Context, emails []string) (map[string]string, error) {
+		asked = emails
+		return map[string]string{"asmith@alumni.uni.edu": "ASmith"}, nil
+	}
+
+	names := []string{"JSmith", "jsmith@UNI.EDU", "UNI\\jsmith", "jane.doe", "asmith@alumni.uni.edu", "nobody@gmail.com", "Jane Smith"}
+	uids, rejected, err := p.Resolve(context.Background(), names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"JSmith":                "jsmith",
+		"jsmith@UNI.EDU":        "jsmith",
+		"UNI\\jsmith":           "jsmith",
+		"jane.doe":              "jdoe",
+		"asmith@alumni.uni.edu": "asmith",
+	}
+	for raw, uid := range want {
+		if uids[raw] != uid {
+			t.Errorf("%q resolved to %q, wanted %q\n", raw, uids[raw], uid)
+		}
+	}
+	if rejected["nobody@gmail.com"] != "unresolved" || rejected["Jane Smith"] != "invalid_char" {
+		t.Errorf("got rejections %v\n", rejected)
+	}
+	if len(asked) != 2 {
+		t.Errorf("asked the directory for %v, wanted only the remaining email addresses\n", asked)
+	}
+}
@@ -0,0 +1,112 @@
+package jamf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/logging"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// License statuses written to the ExtensionAttributeID attribute. A
+// failure is written as "failed: " followed by the reason.
+const (
+	StatusLicensed   = "licensed"
+	StatusUnlicensed = "unlicensed"
+	StatusPending    = "pending"
+)
+
+var writeBackErrors = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "mudwork_jamf_writeback_errors_total",
+		Help: "Total number of failures writing license status to the JSS.",
+	},
+)
+
+func init() {
+	metrics.MustRegister(writeBackErrors)
+}
+
+// extensionAttributeUpdate is the body of a computer inventory PATCH
+// that sets one extension attribute
+type extensionAttributeUpdate struct {
+	ExtensionAttributes []extensionAttributeValue `json:"extensionAttributes"`
+}
+
+type extensionAttributeValue struct {
+	DefinitionID string   `json:"definitionId"`
+	Values       []string `json:"values"`
+}
+
+// Failed is the status written when Adobe or Mudwork turned a change
+// down for reason
+func Failed(reason string) string {
+	return "failed: " + reason
+}
+
+// WriteBack sets the computer extension attribute ExtensionAttributeID
+// to status on every computer assigned to user, so that smart groups
+// and reports in Jamf show the user's Adobe license. It does nothing
+// unless an attribute is configured. Failures are logged and counted
+// but don't hold up the sync.
+func WriteBack(ctx context.Context, user, status string) {
+	if config.C.ExtensionAttributeID == 0 {
+		return
+	}
+	if err := writeBack(ctx, user, status); err != nil {
+		writeBackErrors.Inc()
+		logging.From(ctx).WithFields(log.Fields{
+			"user":   user,
+			"status": status,
+			"error":  err,
+		}).Warn("Unable to write license status to JSS")
+	}
+}
+
+func writeBack(ctx context.Context, user, status string) error {
+	current := userComputers(user)
+	ids := current
+	if len(ids) == 0 {
+		// a user who has left the JSS searches and groups, whose license
+		// is being removed, still has the computers last written to
+		ids = data.GetWriteBackDevices(user)
+	}
+	body, err := json.Marshal(extensionAttributeUpdate{
+		ExtensionAttributes: []extensionAttributeValue{{
+			DefinitionID: strconv.Itoa(config.C.ExtensionAttributeID),
+			Values:       []string{status},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := do(ctx, "PATCH", fmt.Sprintf("/api/v1/computers-inventory-detail/%d", id), body); err != nil {
+			return fmt.Errorf("computer %d: %v", id, err)
+		}
+	}
+	return data.SetWriteBackDevices(user, current)
+}
+
+// userComputers returns the IDs of the computers the last sync found
+// licensing user. They come from the license sources rather than a
+// search by username, since the username in the JSS may only match user
+// once it has been normalized.
+func userComputers(user string) []int {
+	ids := []int{}
+	seen := make(map[int]bool)
+	for _, j := range data.GetLicenseSources(user) {
+		// a computer can be in more than one search or group
+		if j.Kind != KindComputer || seen[j.DeviceID] {
+			continue
+		}
+		seen[j.DeviceID] = true
+		ids = append(ids, j.DeviceID)
+	}
+	return ids
+}
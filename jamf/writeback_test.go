@@ -0,0 +1,80 @@
+package jamf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWriteBack(t *testing.T) {
+	patched := []string{}
+	var update extensionAttributeUpdate
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/auth/token":
+			json.NewEncoder(w).Encode(tokenResponse{Token: "t1", Expires: time.Now().Add(time.Hour)})
+		case r.Method == "PATCH":
+			patched = append(patched, r.URL.Path)
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &update)
+			fmt.Fprint(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	saved, savedAuth := config.C, Auth
+	defer func() { config.C, Auth = saved, savedAuth }()
+	config.C.JssUrl, config.C.ExtensionAttributeID = ts.URL, 5
+	Auth = NewSession(AuthBasic)
+
+	// jdoe's username in the JSS is JDoe@uni.edu, which is only
+	// jdoe once normalized, so the devices come from the sync
+	err := data.ReplaceLicenseSources([]data.LicenseSource{
+		{UniqueID: "jdoe", Kind: KindComputer, DeviceID: 10, Source: "computer group Lab"},
+		{UniqueID: "jdoe", Kind: KindComputer, DeviceID: 10, Source: "computer group Staff"},
+		{UniqueID: "jdoe", Kind: KindComputer, DeviceID: 12, Source: "computer group Staff"},
+		{UniqueID: "jdoe", Kind: KindMobile, DeviceID: 40, Source: "mobile device group iPads"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.ReplaceLicenseSources(nil)
+	defer data.SetWriteBackDevices("jdoe", nil)
+
+	if err := writeBack(context.Background(), "jdoe", Failed("error.user.nonexistent")); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(patched)
+	if len(patched) != 2 || patched[0] != "/api/v1/computers-inventory-detail/10" || patched[1] != "/api/v1/computers-inventory-detail/12" {
+		t.Errorf("patched %v, wanted both of jdoe's computers once\n", patched)
+	}
+	ea := update.ExtensionAttributes
+	if len(ea) != 1 || ea[0].DefinitionID != "5" || len(ea[0].Values) != 1 || ea[0].Values[0] != "failed: error.user.nonexistent" {
+		t.Errorf("got %+v\n", update)
+	}
+
+	// once jdoe has left the JSS, the removal is still written to the
+	// computers written to before, and then they are forgotten
+	if err := data.ReplaceLicenseSources(nil); err != nil {
+		t.Fatal(err)
+	}
+	patched = patched[:0]
+	if err := writeBack(context.Background(), "jdoe", StatusUnlicensed); err != nil {
+		t.Fatal(err)
+	}
+	if len(patched) != 2 || update.ExtensionAttributes[0].Values[0] != StatusUnlicensed {
+		t.Errorf("patched %v with %+v, wanted both computers unlicensed\n", patched, update)
+	}
+	if got := data.GetWriteBackDevices("jdoe"); len(got) != 0 {
+		t.Errorf("still recorded %v for jdoe\n", got)
+	}
+}
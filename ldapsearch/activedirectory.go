@@ -0,0 +1,55 @@
+package ldapsearch
+
+import (
+	"github.com/cosmouser/mudwork/config"
+	"strings"
+)
+
+// ActiveDirectoryServer is the domain described by the ActiveDirectory
+// settings. Users are found by sAMAccountName unless LookupBy asks for
+// userPrincipalName.
+func ActiveDirectoryServer() Server {
+	c := config.C.ActiveDirectory
+	s := Server{
+		Name:             "ad",
+		Url:              c.Url,
+		Port:             c.Port,
+		Base:             c.Base,
+		StartTLS:         c.StartTLS,
+		CACert:           c.CACert,
+		BindDN:           c.BindDN,
+		BindPassword:     c.BindPassword,
+		BindPasswordFile: c.BindPasswordFile,
+		Timeout:          c.Timeout,
+		UidAttribute:     "sAMAccountName",
+		Attributes: AttributeMap{
+			Email:     c.Attributes.Email,
+			FirstName: c.Attributes.FirstName,
+			LastName:  c.Attributes.LastName,
+			Country:   c.Attributes.Country,
+		},
+	}
+	if strings.EqualFold(c.LookupBy, "userPrincipalName") {
+		s.UidAttribute = "userPrincipalName"
+		s.UpnSuffix = c.UpnSuffix
+	}
+	if len(s.Attributes.Email) == 0 {
+		s.Attributes.Email = []string{"mail"}
+	}
+	if len(s.Attributes.FirstName) == 0 {
+		s.Attributes.FirstName = []string{"givenName"}
+	}
+	if len(s.Attributes.LastName) == 0 {
+		s.Attributes.LastName = []string{"sn"}
+	}
+	if len(s.Attributes.Country) == 0 {
+		s.Attributes.Country = []string{"c"}
+	}
+	return s
+}
+
+// NewActiveDirectory returns a client for the ActiveDirectory settings
+func NewActiveDirectory() *Client {
+	c := config.C.ActiveDirectory
+	return newPooledClient(ActiveDirectoryServer(), c.PoolSize, c.CacheTTL)
+}
@@ -0,0 +1,97 @@
+package ldapsearch
+
+import (
+	"github.com/cosmouser/mudwork/config"
+	"strings"
+)
+
+// AttributeMap lists, for each Person field, the directory attributes
+// to read in order of preference. The first one with a value is used.
+type AttributeMap struct {
+	Email     []string
+	FirstName []string
+	LastName  []string
+	Country   []string
+}
+
+// Attributes is built from LdapAttributes, falling back to the older
+// LdapFirstName, LdapLastName and LdapEmail settings for any field the
+// map leaves out
+var Attributes AttributeMap
+
+func init() {
+	Attributes = newAttributeMap(config.C)
+}
+
+func newAttributeMap(c config.Config) AttributeMap {
+	m := AttributeMap{
+		Email:     c.LdapAttributes.Email,
+		FirstName: c.LdapAttributes.FirstName,
+		LastName:  c.LdapAttributes.LastName,
+		Country:   c.LdapAttributes.Country,
+	}
+	if len(m.Email) == 0 && c.LdapEmail != "" {
+		m.Email = []string{c.LdapEmail}
+	}
+	if len(m.FirstName) == 0 && c.LdapFirstName != "" {
+		m.FirstName = []string{c.LdapFirstName}
+	}
+	if len(m.LastName) == 0 && c.LdapLastName != "" {
+		m.LastName = []string{c.LdapLastName}
+	}
+	return m
+}
+
+// names returns every attribute in the map
+func (m AttributeMap) names() []string {
+	names := []string{}
+	for _, j := range [][]string{m.Email, m.FirstName, m.LastName, m.Country} {
+		names = append(names, j...)
+	}
+	return names
+}
+
+// Value returns the first value of the first attribute in names that
+// the person has. Attribute names are compared without regard to case.
+func (p *Person) Value(names []string) string {
+	for _, name := range names {
+		for k, v := range p.Attributes {
+			if strings.EqualFold(k, name) && len(v) > 0 && v[0] != "" {
+				return v[0]
+			}
+		}
+	}
+	return ""
+}
+
+// DefaultCountry is used when a person has no valid country code
+func DefaultCountry() string {
+	if config.C.DefaultCountry != "" {
+		return config.C.DefaultCountry
+	}
+	return "US"
+}
+
+// countryCode returns value as an ISO 3166 alpha-2 code, or "" when it
+// isn't one
+func countryCode(value string) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if len(value) != 2 || value[0] < 'A' || value[0] > 'Z' || value[1] < 'A' || value[1] > 'Z' {
+		return ""
+	}
+	return value
+}
+
+// fill sets the Person fields from their mapped attributes
+func (p *Person) fill(m AttributeMap) {
+	p.FirstName = p.Value(m.FirstName)
+	p.LastName = p.Value(m.LastName)
+	p.Email = p.Value(m.Email)
+	if p.Email == "" {
+		p.Email = DefaultEmail(p.Uid)
+	}
+	p.Country = countryCode(p.Value(m.Country))
+	if p.Country == "" {
+		p.Country = DefaultCountry()
+	}
+}
@@ -0,0 +1,270 @@
+package ldapsearch
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/cosmouser/mudwork/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/ldap.v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client looks people up in the directory. It keeps a small pool of
+// bound connections so a batch of lookups doesn't dial and bind for
+// every user, and caches results briefly so repeated syncs don't ask
+// for the same people again.
+type Client struct {
+	server    Server
+	pool      chan *ldap.Conn
+	batchSize int
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	result  Result
+	expires time.Time
+}
+
+// Result is the outcome of looking up one uid. Err is ErrNotFound or
+// ErrMultipleEntries when the directory has no single entry for it.
+type Result struct {
+	Person *Person
+	Err    error
+}
+
+// Default is the client for the Ldap settings used by GetPerson and
+// GetPeople
+var Default *Client
+
+var (
+	lookupDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mudwork_ldap_lookup_duration_seconds",
+			Help:    "Time taken by each search of an LDAP directory, by directory.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"directory"},
+	)
+	lookupFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mudwork_ldap_lookup_failures_total",
+			Help: "Total number of LDAP searches that failed because the directory was unavailable, by directory.",
+		},
+		[]string{"directory"},
+	)
+)
+
+func init() {
+	metrics.MustRegister(lookupDuration)
+	metrics.MustRegister(lookupFailures)
+	Default = newPooledClient(LDAPServer(), config.C.LdapPoolSize, config.C.LdapCacheTTL)
+}
+
+// newPooledClient applies the default pool size of 4 and cache TTL of
+// 300 seconds when size or ttl isn't set. A ttl of 0 disables the cache.
+func newPooledClient(s Server, size int, ttl *int) *Client {
+	if size <= 0 {
+		size = 4
+	}
+	seconds := 300
+	if ttl != nil {
+		seconds = *ttl
+	}
+	return NewClient(s, size, time.Duration(seconds)*time.Second)
+}
+
+// NewClient returns a client for s that keeps up to poolSize idle
+// connections and caches results for ttl. A ttl of 0 disables the cache.
+func NewClient(s Server, poolSize int, ttl time.Duration) *Client {
+	return &Client{
+		server:    s,
+		pool:      make(chan *ldap.Conn, poolSize),
+		batchSize: 50,
+		ttl:       ttl,
+		cache:     make(map[string]cached),
+	}
+}
+
+// conn takes an idle connection from the pool or dials a new one
+func (c *Client) conn() (*ldap.Conn, error) {
+	select {
+	case l := <-c.pool:
+		return l, nil
+	default:
+		return c.server.dial()
+	}
+}
+
+// Name identifies the directory, as recorded in Person.Source
+func (c *Client) Name() string {
+	return c.server.Name
+}
+
+// release returns a connection to the pool, closing it if it failed
+// or the pool is full
+func (c *Client) release(l *ldap.Conn, err error) {
+	if err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		l.Close()
+		return
+	}
+	select {
+	case c.pool <- l:
+	default:
+		l.Close()
+	}
+}
+
+// search runs a subtree search under the server's Base. A pooled connection that
+// turns out to be dead is replaced once before giving up. Any failure is
+// returned as an *UnavailableError.
+func (c *Client) search(ctx context.Context, filter string) (sr *ldap.SearchResult, err error) {
+	_, span := tracing.Start(ctx, "ldap.search", attribute.String("ldap.directory", c.server.Name))
+	start := time.Now()
+	defer func() {
+		labels := prometheus.Labels{"directory": c.server.Name}
+		lookupDuration.With(labels).Observe(time.Since(start).Seconds())
+		if err != nil {
+			lookupFailures.With(labels).Inc()
+		}
+		if sr != nil {
+			span.SetAttributes(attribute.Int("ldap.entries", len(sr.Entries)))
+		}
+		tracing.End(span, err)
+	}()
+	searchRequest := ldap.NewSearchRequest(
+		c.server.Base,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		c.server.attributes(),
+		nil,
+	)
+	for attempt := 0; ; attempt++ {
+		l, err := c.conn()
+		if err != nil {
+			return nil, &UnavailableError{err}
+		}
+		sr, err := l.Search(searchRequest)
+		c.release(l, err)
+		if err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, &UnavailableError{err}
+		}
+		return sr, nil
+	}
+}
+
+// GetPerson looks up a single user. It returns ErrNotFound or
+// ErrMultipleEntries when the directory has no single entry for uid and
+// an *UnavailableError when the directory can't be reached.
+func (c *Client) GetPerson(ctx context.Context, uid string) (*Person, error) {
+	results, err := c.GetPeople(ctx, []string{uid})
+	if err != nil {
+		return nil, err
+	}
+	r := results[uid]
+	return r.Person, r.Err
+}
+
+// GetPeople looks up uids with as few searches as possible, ORing up to
+// batchSize uids into each filter. Every uid gets a Result, keyed by the
+// uid as given. The error is non-nil only when the directory couldn't
+// be asked, in which case it is an *UnavailableError and no results are
+// returned.
+func (c *Client) GetPeople(ctx context.Context, uids []string) (results map[string]Result, err error) {
+	ctx, span := tracing.Start(ctx, "ldap.lookup",
+		attribute.String("ldap.directory", c.server.Name),
+		attribute.Int("ldap.uids", len(uids)))
+	defer func() { tracing.End(span, err) }()
+	results = make(map[string]Result)
+	// directory uids are matched without regard to case
+	wanted := make(map[string][]string)
+	now := time.Now()
+	c.mu.Lock()
+	for _, j := range uids {
+		if hit, ok := c.cache[strings.ToLower(j)]; ok && now.Before(hit.expires) {
+			results[j] = hit.result.as(j)
+			continue
+		}
+		key := strings.ToLower(j)
+		wanted[key] = append(wanted[key], j)
+	}
+	c.mu.Unlock()
+	span.SetAttributes(attribute.Int("ldap.cached", len(results)))
+
+	pending := make([]string, 0, len(wanted))
+	for _, j := range wanted {
+		pending = append(pending, j[0])
+	}
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > c.batchSize {
+			n = c.batchSize
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+		sr, err := c.search(ctx, c.server.filter(batch))
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[string]Result)
+		for _, entry := range sr.Entries {
+			key := strings.ToLower(c.server.uidOf(entry))
+			if _, ok := wanted[key]; !ok {
+				continue
+			}
+			if _, dup := found[key]; dup {
+				found[key] = Result{Err: ErrMultipleEntries}
+				continue
+			}
+			found[key] = Result{Person: c.server.newPerson(wanted[key][0], entry)}
+		}
+		c.mu.Lock()
+		for _, j := range batch {
+			key := strings.ToLower(j)
+			r, ok := found[key]
+			if !ok {
+				r = Result{Err: ErrNotFound}
+			}
+			if c.ttl > 0 {
+				c.cache[key] = cached{r, now.Add(c.ttl)}
+			}
+			for _, uid := range wanted[key] {
+				results[uid] = r.as(uid)
+			}
+		}
+		c.mu.Unlock()
+	}
+	c.expire(now)
+	return results, nil
+}
+
+// as returns a copy of the result for uid, which may differ in case
+// from the uid that was looked up
+func (r Result) as(uid string) Result {
+	if r.Person == nil {
+		return r
+	}
+	p := *r.Person
+	p.Uid = uid
+	return Result{Person: &p}
+}
+
+// expire drops stale cache entries
+func (c *Client) expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.cache {
+		if !now.Before(v.expires) {
+			delete(c.cache, k)
+		}
+	}
+}
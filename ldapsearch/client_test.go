@@ -0,0 +1,187 @@
+package ldapsearch
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetPeopleCached(t *testing.T) {
+	c := NewClient(Server{Name: "ldap", UidAttribute: "uid"}, 1, time.Minute)
+	expires := time.Now().Add(time.Minute)
+	c.cache["jdoe"] = cached{Result{Person: &Person{Uid: "jdoe", FirstName: "Jane"}}, expires}
+	c.cache["gone"] = cached{Result{Err: ErrNotFound}, expires}
+	c.cache["twin"] = cached{Result{Err: ErrMultipleEntries}, expires}
+
+	results, err := c.GetPeople(context.Background(), []string{"JDoe", "gone", "twin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := results["JDoe"].Person; p == nil || p.Uid != "JDoe" || p.FirstName != "Jane" {
+		t.Errorf("got %+v for JDoe, wanted the cached person under the uid as given\n", results["JDoe"])
+	}
+	if err := results["gone"].Err; err != ErrNotFound {
+		t.Errorf("got %v for gone, wanted ErrNotFound\n", err)
+	}
+	if _, err := c.GetPerson(context.Background(), "twin"); err != ErrMultipleEntries {
+		t.Errorf("got %v for twin, wanted ErrMultipleEntries\n", err)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	ln, asked := fakeDirectory(t, map[string][]string{"uid": {"jdoe"}})
+	defer ln.Close()
+	zero, minute := 0, 60
+	tests := []struct {
+		name     string
+		ttl      *int
+		want     time.Duration
+		searches int
+	}{
+		{"unset", nil, 300 * time.Second, 1},
+		{"disabled", &zero, 0, 2},
+		{"set", &minute, time.Minute, 1},
+	}
+	for _, tt := range tests {
+		c := newPooledClient(Server{Name: "ldap", Url: "ldap://" + ln.Addr().String(), UidAttribute: "uid"}, 1, tt.ttl)
+		if c.ttl != tt.want {
+			t.Errorf("%s: got ttl %v, wanted %v\n", tt.name, c.ttl, tt.want)
+		}
+		// a miss is cached like a hit
+		for _, uid := range []string{"jdoe", "jdoe", "newhire", "newhire"} {
+			if _, err := c.GetPeople(context.Background(), []string{uid}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var searches int
+		for len(asked) > 0 {
+			<-asked
+			searches++
+		}
+		if searches != 2*tt.searches {
+			t.Errorf("%s: searched %d times, wanted %d\n", tt.name, searches, 2*tt.searches)
+		}
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	if !IsUnavailable(&UnavailableError{ErrNotFound}) {
+		t.Error("IsUnavailable returned false for an UnavailableError")
+	}
+	if IsUnavailable(ErrNotFound) {
+		t.Error("IsUnavailable returned true for ErrNotFound")
+	}
+}
+
+func TestSearchAttributes(t *testing.T) {
+	saved := config.C
+	defer func() { config.C = saved }()
+	config.C.Eligibility.Rules = []config.EligibilityRule{{Attribute: "memberOf"}}
+	config.C.Quota.PriorityAttribute = "eduPersonAffiliation"
+	ln, asked := fakeDirectory(t, map[string][]string{
+		"uid":                  {"jdoe"},
+		"eduPersonAffiliation": {"staff"},
+	})
+	defer ln.Close()
+	c := NewClient(Server{Name: "ldap", Url: "ldap://" + ln.Addr().String(), UidAttribute: "uid"}, 1, time.Minute)
+
+	p, err := c.GetPerson(context.Background(), "jdoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"uid": true, "memberOf": true, "eduPersonAffiliation": true}
+	for _, j := range <-asked {
+		delete(want, j)
+	}
+	if len(want) != 0 {
+		t.Errorf("search didn't ask for %v\n", want)
+	}
+	if got := p.Attributes["eduPersonAffiliation"]; len(got) != 1 || got[0] != "staff" {
+		t.Errorf("got %v for eduPersonAffiliation, wanted staff\n", got)
+	}
+}
+
+// fakeDirectory serves anonymous searches on a local port until the
+// listener is closed, answering each with entries. Like a real server it
+// only returns the attributes a search asks for, which are also sent on
+// asked.
+func fakeDirectory(t *testing.T, entries ...map[string][]string) (net.Listener, chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asked := make(chan []string, 10)
+	reply := func(conn net.Conn, id interface{}, op *ber.Packet) {
+		p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+		p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+		p.AppendChild(op)
+		conn.Write(p.Bytes())
+	}
+	str := func(v string) *ber.Packet {
+		return ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "")
+	}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			p, err := ber.ReadPacket(conn)
+			if err != nil || len(p.Children) < 2 || p.Children[1].Tag != ldap.ApplicationSearchRequest {
+				return
+			}
+			id, req := p.Children[0].Value, p.Children[1]
+			attrs := []string{}
+			for _, j := range req.Children[7].Children {
+				attrs = append(attrs, j.Value.(string))
+			}
+			asked <- attrs
+			for _, e := range entries {
+				op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+				op.AppendChild(str("uid=" + e["uid"][0] + ",dc=uni,dc=edu"))
+				list := ber.NewSequence("Attributes")
+				for name, values := range e {
+					if !contains(attrs, name) {
+						continue
+					}
+					a := ber.NewSequence("Attribute")
+					a.AppendChild(str(name))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					for _, v := range values {
+						set.AppendChild(str(v))
+					}
+					a.AppendChild(set)
+					list.AppendChild(a)
+				}
+				op.AppendChild(list)
+				reply(conn, id, op)
+			}
+			op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultDone, nil, "Search Result Done")
+			op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, "Result Code"))
+			op.AppendChild(str(""))
+			op.AppendChild(str(""))
+			reply(conn, id, op)
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln, asked
+}
+
+func contains(list []string, s string) bool {
+	for _, j := range list {
+		if strings.EqualFold(j, s) {
+			return true
+		}
+	}
+	return false
+}
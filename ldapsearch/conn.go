@@ -0,0 +1,181 @@
+package ldapsearch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/logging"
+	"gopkg.in/ldap.v2"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server describes a directory and how users are found in it. Name is
+// recorded on every Person the server returns. UidAttribute holds the
+// uid, and when UpnSuffix is set uids are looked up as uid@UpnSuffix.
+type Server struct {
+	Name             string
+	Url              string
+	Port             int
+	Base             string
+	StartTLS         bool
+	CACert           string
+	BindDN           string
+	BindPassword     string
+	BindPasswordFile string
+	Timeout          int
+	UidAttribute     string
+	UpnSuffix        string
+	Attributes       AttributeMap
+}
+
+// LDAPServer is the directory described by the Ldap settings
+func LDAPServer() Server {
+	return Server{
+		Name:             "ldap",
+		Url:              config.C.LdapUrl,
+		Port:             config.C.LdapPort,
+		Base:             config.C.LdapBase,
+		StartTLS:         config.C.LdapStartTLS,
+		CACert:           config.C.LdapCACert,
+		BindDN:           config.C.LdapBindDN,
+		BindPassword:     config.C.LdapBindPassword,
+		BindPasswordFile: config.C.LdapBindPasswordFile,
+		Timeout:          config.C.LdapTimeout,
+		UidAttribute:     "uid",
+		Attributes:       Attributes,
+	}
+}
+
+// endpoint works out the host, port and whether to use implicit TLS
+// from Url, which may be a bare host name or an ldap:// or ldaps:// URL.
+// An explicit port in the URL wins over Port.
+func (s Server) endpoint() (host string, port int, useTLS bool, err error) {
+	host, port = s.Url, s.Port
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("ldapsearch: invalid %s Url %q: %v", s.Name, s.Url, err)
+		}
+		switch u.Scheme {
+		case "ldap":
+		case "ldaps":
+			useTLS = true
+		default:
+			return "", 0, false, fmt.Errorf("ldapsearch: unsupported %s Url scheme %q", s.Name, u.Scheme)
+		}
+		host = u.Hostname()
+		if p := u.Port(); p != "" {
+			port, err = strconv.Atoi(p)
+			if err != nil {
+				return "", 0, false, fmt.Errorf("ldapsearch: invalid port in %s Url %q", s.Name, s.Url)
+			}
+		}
+	}
+	if port == 0 {
+		port = 389
+		if useTLS {
+			port = 636
+		}
+	}
+	return host, port, useTLS, nil
+}
+
+// timeout is used both for dialing and for each request
+func (s Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return time.Duration(s.Timeout) * time.Second
+	}
+	return time.Second * 10
+}
+
+// tlsConfig verifies the server as host, trusting CACert in place of
+// the system roots when it is set
+func (s Server) tlsConfig(host string) (*tls.Config, error) {
+	c := &tls.Config{ServerName: host}
+	if s.CACert != "" {
+		pem, err := ioutil.ReadFile(s.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("ldapsearch: unable to read %s CACert: %v", s.Name, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ldapsearch: no certificates found in %s", s.CACert)
+		}
+		c.RootCAs = pool
+	}
+	return c, nil
+}
+
+// bindPassword returns BindPassword, or the contents of BindPasswordFile
+// without its trailing newline
+func (s Server) bindPassword() (string, error) {
+	if s.BindPasswordFile == "" {
+		return s.BindPassword, nil
+	}
+	pw, err := ioutil.ReadFile(s.BindPasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("ldapsearch: unable to read %s BindPasswordFile: %v", s.Name, err)
+	}
+	password := strings.TrimRight(string(pw), "\r\n")
+	logging.SetSecret(s.Name+".BindPasswordFile", password)
+	return password, nil
+}
+
+// dial connects to the directory over LDAPS or plain LDAP, upgrades the
+// connection with StartTLS when StartTLS is set and binds as BindDN when
+// one is configured. Without a bind DN the connection stays anonymous.
+func (s Server) dial() (*ldap.Conn, error) {
+	host, port, useTLS, err := s.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	var tc *tls.Config
+	if useTLS || s.StartTLS {
+		tc, err = s.tlsConfig(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dialer := &net.Dialer{Timeout: s.timeout()}
+	var l *ldap.Conn
+	if useTLS {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tc)
+		if err != nil {
+			return nil, fmt.Errorf("ldapsearch: TLS connection to %s failed: %v", addr, err)
+		}
+		l = ldap.NewConn(conn, true)
+	} else {
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("ldapsearch: connection to %s failed: %v", addr, err)
+		}
+		l = ldap.NewConn(conn, false)
+	}
+	l.Start()
+	l.SetTimeout(s.timeout())
+	if s.StartTLS && !useTLS {
+		if err := l.StartTLS(tc); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("ldapsearch: StartTLS with %s failed: %v", addr, err)
+		}
+	}
+	if s.BindDN != "" {
+		pw, err := s.bindPassword()
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		if err := l.Bind(s.BindDN, pw); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("ldapsearch: bind as %s failed: %v", s.BindDN, err)
+		}
+	}
+	return l, nil
+}
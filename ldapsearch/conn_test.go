@@ -0,0 +1,64 @@
+package ldapsearch
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		url    string
+		port   int
+		host   string
+		want   int
+		useTLS bool
+		fails  bool
+	}{
+		{"ldap.uni.edu", 0, "ldap.uni.edu", 389, false, false},
+		{"ldap.uni.edu", 1389, "ldap.uni.edu", 1389, false, false},
+		{"ldap://ldap.uni.edu", 0, "ldap.uni.edu", 389, false, false},
+		{"ldaps://ldap.uni.edu", 0, "ldap.uni.edu", 636, true, false},
+		{"ldaps://ldap.uni.edu:3269", 636, "ldap.uni.edu", 3269, true, false},
+		{"ldap://ldap.uni.edu:3268", 389, "ldap.uni.edu", 3268, false, false},
+		{"ldaps://ldap.uni.edu:tls", 0, "", 0, false, true},
+		{"http://ldap.uni.edu", 0, "", 0, false, true},
+	}
+	for _, tt := range tests {
+		host, port, useTLS, err := Server{Name: "ldap", Url: tt.url, Port: tt.port}.endpoint()
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: got error %v, wanted one: %v\n", tt.url, err, tt.fails)
+			continue
+		}
+		if host != tt.host || port != tt.want || useTLS != tt.useTLS {
+			t.Errorf("%s with port %d: got %s %d %v, wanted %s %d %v\n", tt.url, tt.port, host, port, useTLS, tt.host, tt.want, tt.useTLS)
+		}
+	}
+}
+
+func TestBindPassword(t *testing.T) {
+	file, err := ioutil.TempFile("", "ldappass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("s3cret pass\r\n")
+	file.Close()
+	tests := []struct {
+		name string
+		s    Server
+		want string
+	}{
+		{"inline", Server{BindPassword: "inline pass\n"}, "inline pass\n"},
+		{"file", Server{BindPassword: "ignored", BindPasswordFile: file.Name()}, "s3cret pass"},
+	}
+	for _, tt := range tests {
+		got, err := tt.s.bindPassword()
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, wanted %q\n", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := (Server{Name: "ldap", BindPasswordFile: file.Name() + ".missing"}).bindPassword(); err == nil {
+		t.Error("got no error for a missing BindPasswordFile")
+	}
+}
@@ -0,0 +1,67 @@
+package ldapsearch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// GetUidsByEmail finds the uid of each person whose mapped email
+// attributes hold one of emails. The result is keyed by the lower case
+// email; an address found on more than one entry is left out. The
+// error is non-nil only when the directory couldn't be asked.
+func (c *Client) GetUidsByEmail(ctx context.Context, emails []string) (map[string]string, error) {
+	uids := make(map[string]string)
+	if len(c.server.Attributes.Email) == 0 {
+		return uids, nil
+	}
+	ambiguous := make(map[string]bool)
+	for len(emails) > 0 {
+		n := len(emails)
+		if n > c.batchSize {
+			n = c.batchSize
+		}
+		batch := emails[:n]
+		emails = emails[n:]
+		wanted := make(map[string]bool)
+		for _, j := range batch {
+			wanted[strings.ToLower(j)] = true
+		}
+		sr, err := c.search(ctx, anyFilter(c.server.Attributes.Email, batch))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range sr.Entries {
+			uid := c.server.uidOf(entry)
+			for _, attr := range c.server.Attributes.Email {
+				for _, value := range entry.GetAttributeValues(attr) {
+					key := strings.ToLower(value)
+					if !wanted[key] {
+						continue
+					}
+					if found, ok := uids[key]; ok && !strings.EqualFold(found, uid) {
+						ambiguous[key] = true
+					}
+					uids[key] = uid
+				}
+			}
+		}
+	}
+	for k := range ambiguous {
+		delete(uids, k)
+	}
+	return uids, nil
+}
+
+// anyFilter matches entries where any of attrs has any of values
+func anyFilter(attrs, values []string) string {
+	var b strings.Builder
+	b.WriteString("(|")
+	for _, value := range values {
+		for _, attr := range attrs {
+			fmt.Fprintf(&b, "(%s=%s)", attr, EscapeFilter(value))
+		}
+	}
+	b.WriteString(")")
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package ldapsearch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound means the directory answered and has no entry for the uid
+var ErrNotFound = errors.New("ldapsearch: no entry for uid")
+
+// ErrMultipleEntries means the uid matched more than one entry, so the
+// directory can't say which person is meant
+var ErrMultipleEntries = errors.New("ldapsearch: uid matched more than one entry")
+
+// UnavailableError means the directory could not be asked: the
+// connection, TLS handshake, bind or search failed. It says nothing
+// about whether the user exists.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("ldapsearch: directory unavailable: %v", e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// IsUnavailable reports whether err came from an unreachable directory
+// rather than from the directory's answer
+func IsUnavailable(err error) bool {
+	var u *UnavailableError
+	return errors.As(err, &u)
+}
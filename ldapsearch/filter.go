@@ -0,0 +1,22 @@
+package ldapsearch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EscapeFilter escapes a value for use in an LDAP search filter as
+// described in RFC 4515, so that characters like *, ( and ) in a
+// username match themselves rather than changing the filter.
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package ldapsearch
+
+import (
+	"gopkg.in/ldap.v2"
+	"testing"
+)
+
+func TestEscapeFilter(t *testing.T) {
+	tests := map[string]string{
+		"jdoe":           "jdoe",
+		"*":              "\\2a",
+		"jdoe)(uid=*":    "jdoe\\29\\28uid=\\2a",
+		"back\\slash":    "back\\5cslash",
+		"nul\x00":        "nul\\00",
+		"Lucía":          "Lucía",
+		"(&(objectClass": "\\28&\\28objectClass",
+	}
+	for value, want := range tests {
+		if got := EscapeFilter(value); got != want {
+			t.Errorf("EscapeFilter(%q) = %q, wanted %q\n", value, got, want)
+		}
+	}
+}
+
+func TestUidFilter(t *testing.T) {
+	if got, want := uidFilter("uid", []string{"jdoe"}), "(&(uid=jdoe))"; got != want {
+		t.Errorf("got %s, wanted %s\n", got, want)
+	}
+	if got, want := uidFilter("uid", []string{"jdoe", "a*"}), "(|(uid=jdoe)(uid=a\\2a))"; got != want {
+		t.Errorf("got %s, wanted %s\n", got, want)
+	}
+	if got, want := anyFilter([]string{"mail", "mailPreferred"}, []string{"j*@uni.edu"}), "(|(mail=j\\2a@uni.edu)(mailPreferred=j\\2a@uni.edu))"; got != want {
+		t.Errorf("got %s, wanted %s\n", got, want)
+	}
+	ad := Server{UidAttribute: "userPrincipalName", UpnSuffix: "ad.uni.edu"}
+	if got, want := ad.filter([]string{"jdoe"}), "(&(userPrincipalName=jdoe@ad.uni.edu))"; got != want {
+		t.Errorf("got %s, wanted %s\n", got, want)
+	}
+	entry := ldap.NewEntry("cn=jdoe", map[string][]string{"userPrincipalName": {"jdoe@AD.uni.edu"}})
+	if got := ad.uidOf(entry); got != "jdoe" {
+		t.Errorf("uidOf returned %q, wanted jdoe\n", got)
+	}
+}
+
+func TestFill(t *testing.T) {
+	m := AttributeMap{
+		Email:     []string{"mailPreferred", "mail"},
+		FirstName: []string{"preferredGivenName", "givenName"},
+		LastName:  []string{"sn"},
+		Country:   []string{"c"},
+	}
+	p := &Person{Uid: "jdoe", Attributes: map[string][]string{
+		"mail":      {"jane.doe@uni.edu"},
+		"givenname": {"Jane"},
+		"sn":        {"Doe"},
+		"c":         {"ca"},
+	}}
+	p.fill(m)
+	if p.Email != "jane.doe@uni.edu" || p.FirstName != "Jane" || p.LastName != "Doe" || p.Country != "CA" {
+		t.Errorf("fill produced %+v\n", *p)
+	}
+	p.Attributes["mailPreferred"] = []string{"jd@uni.edu"}
+	p.Attributes["c"] = []string{"Canada"}
+	p.fill(m)
+	if p.Email != "jd@uni.edu" {
+		t.Errorf("got email %s, wanted the preferred alias\n", p.Email)
+	}
+	if p.Country != DefaultCountry() {
+		t.Errorf("got country %s for an invalid code, wanted %s\n", p.Country, DefaultCountry())
+	}
+}
@@ -1,41 +1,106 @@
 package ldapsearch
-  
+
 import (
-        "fmt"
-        "github.com/cosmouser/mudwork/config"
-        "gopkg.in/ldap.v2"
+	"context"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"gopkg.in/ldap.v2"
+	"strings"
 )
 
 type Person struct {
-        FirstName string
-        LastName  string
-        Email     string
-        Uid       string
-}
-
-func GetPerson(uid string) (*Person, error) {
-        l, err := ldap.Dial("tcp", fmt.Sprintf("%s:%d", config.C.LdapUrl, config.C.LdapPort))
-        if err != nil {
-                return nil, err
-        }
-        defer l.Close()
-        searchRequest := ldap.NewSearchRequest(
-                config.C.LdapBase,
-                ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-                fmt.Sprintf("(&(uid=%s))", uid),
-                []string{config.C.LdapFirstName, config.C.LdapLastName},
-                nil,
-        )
-        sr, err := l.Search(searchRequest)
-        if err != nil {
-                return nil, err
-        }
-        p := &Person{}
-        for _, entry := range sr.Entries {
-                p.FirstName = entry.GetAttributeValue(config.C.LdapFirstName)
-                p.LastName = entry.GetAttributeValue(config.C.LdapLastName)
-        }
-        p.Email = fmt.Sprintf("%s@%s", uid, config.C.Enterprise["Domain"])
-        p.Uid = uid
-        return p, nil
+	FirstName string
+	LastName  string
+	Email     string
+	Country   string
+	Uid       string
+	// Source names the directory the person was found in
+	Source string
+	// Attributes holds every attribute requested for the person,
+	// including those named by eligibility rules
+	Attributes map[string][]string
+}
+
+// DefaultEmail is the address used for a user whose directory entry
+// has no email attribute
+func DefaultEmail(uid string) string {
+	return fmt.Sprintf("%s@%s", uid, config.C.Enterprise["Domain"])
+}
+
+// GetPerson looks up a single user through the Default client
+func GetPerson(ctx context.Context, uid string) (*Person, error) {
+	return Default.GetPerson(ctx, uid)
+}
+
+// GetPeople looks up many users at once through the Default client
+func GetPeople(ctx context.Context, uids []string) (map[string]Result, error) {
+	return Default.GetPeople(ctx, uids)
+}
+
+// attributes returns the attributes requested for each person
+func (s Server) attributes() []string {
+	attrs := append([]string{s.UidAttribute}, s.Attributes.names()...)
+	for _, j := range config.C.Eligibility.Rules {
+		attrs = append(attrs, j.Attribute)
+	}
+	// held adds are ranked by it when the quota is reached
+	if config.C.Quota.PriorityAttribute != "" {
+		attrs = append(attrs, config.C.Quota.PriorityAttribute)
+	}
+	return attrs
+}
+
+// filter matches any of uids on the server's UidAttribute
+func (s Server) filter(uids []string) string {
+	values := make([]string, len(uids))
+	for i, j := range uids {
+		values[i] = j
+		if s.UpnSuffix != "" {
+			values[i] = j + "@" + s.UpnSuffix
+		}
+	}
+	return uidFilter(s.UidAttribute, values)
+}
+
+// uidOf returns the uid held by entry, without the UpnSuffix
+func (s Server) uidOf(entry *ldap.Entry) string {
+	uid := entry.GetAttributeValue(s.UidAttribute)
+	if s.UpnSuffix != "" {
+		suffix := "@" + s.UpnSuffix
+		if len(uid) > len(suffix) && strings.EqualFold(uid[len(uid)-len(suffix):], suffix) {
+			uid = uid[:len(uid)-len(suffix)]
+		}
+	}
+	return uid
+}
+
+// uidFilter matches any of values on attr
+func uidFilter(attr string, values []string) string {
+	if len(values) == 1 {
+		return fmt.Sprintf("(&(%s=%s))", attr, EscapeFilter(values[0]))
+	}
+	var b strings.Builder
+	b.WriteString("(|")
+	for _, j := range values {
+		fmt.Fprintf(&b, "(%s=%s)", attr, EscapeFilter(j))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// newPerson builds a Person from a directory entry
+func (s Server) newPerson(uid string, entry *ldap.Entry) *Person {
+	attrs := make(map[string][]string)
+	for _, j := range entry.Attributes {
+		attrs[j.Name] = j.Values
+	}
+	return NewPerson(uid, s.Name, attrs, s.Attributes)
+}
+
+// NewPerson builds a Person found in source from its attributes, filling
+// the Person fields through m
+func NewPerson(uid, source string, attrs map[string][]string, m AttributeMap) *Person {
+	p := &Person{Uid: uid, Source: source, Attributes: attrs}
+	p.fill(m)
+	return p
 }
@@ -0,0 +1,131 @@
+package lifecycle
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/directory"
+	"github.com/cosmouser/mudwork/umapi"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// getUsersInGroup and getPeople are replaced in tests
+var (
+	getUsersInGroup = umapi.GetUsersInGroup
+	getPeople       = directory.GetPeople
+)
+
+// Enabled reports whether the config asks for any lifecycle management
+func Enabled() bool {
+	return config.C.Lifecycle.RemoveAfterDays > 0 || config.C.Lifecycle.SyncNames
+}
+
+// Interval returns how long to wait between runs
+func Interval() time.Duration {
+	if config.C.Lifecycle.Interval > 0 {
+		return time.Duration(config.C.Lifecycle.Interval) * time.Hour
+	}
+	return time.Hour * 24
+}
+
+// Run queues removals for long-unlicensed users and updates for users
+// whose names changed in LDAP. It returns the number of entries queued.
+func Run(token *umapi.AccessResponse) int {
+	var queued int
+	if config.C.Lifecycle.RemoveAfterDays > 0 {
+		queued += queueRemovals(time.Now())
+	}
+	if config.C.Lifecycle.SyncNames {
+		n, err := queueNameUpdates(token)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"function": "queueNameUpdates",
+				"error":    err,
+			}).Error("Unable to compare names with Adobe")
+		}
+		queued += n
+	}
+	return queued
+}
+
+// queueRemovals queues every user who has been unlicensed for longer
+// than RemoveAfterDays for removal from the org or domain
+func queueRemovals(now time.Time) int {
+	txType := "removeFromOrg"
+	if config.C.Lifecycle.RemoveFrom == "domain" {
+		txType = "removeFromDomain"
+	}
+	cutoff := now.AddDate(0, 0, -config.C.Lifecycle.RemoveAfterDays)
+	var queued int
+	for _, j := range data.GetUnlicensedBefore(cutoff) {
+		if queue(j, txType) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// queueNameUpdates compares the names Adobe has for members of the
+// AdobeGroup with LDAP and queues an update for each one that differs
+func queueNameUpdates(token *umapi.AccessResponse) (int, error) {
+	members, err := getUsersInGroup(token, config.C.AdobeGroup)
+	if err != nil {
+		return 0, err
+	}
+	byEmail := make(map[string]umapi.User)
+	for _, j := range members {
+		byEmail[strings.ToLower(j.Email)] = j
+	}
+	users := data.GetUsers()
+	people, err := getPeople(context.Background(), users)
+	if err != nil {
+		return 0, err
+	}
+	var queued int
+	for _, j := range users {
+		// Adobe IDs belong to their owners and can't be updated
+		if umapi.AccountTypeFor(j) == umapi.AdobeID {
+			continue
+		}
+		person := people[j].Person
+		if person == nil || len(person.FirstName) == 0 {
+			continue
+		}
+		member, ok := byEmail[strings.ToLower(person.Email)]
+		if !ok {
+			continue
+		}
+		if member.FirstName == person.FirstName && member.LastName == person.LastName {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"uid":       j,
+			"adobe":     member.FirstName + " " + member.LastName,
+			"directory": person.FirstName + " " + person.LastName,
+		}).Info("Name changed in directory")
+		if queue(j, "update") {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// queue adds an entry to the txlog unless it is already there
+func queue(uid, txType string) bool {
+	entry := &data.TxEntry{UniqueID: uid, TxType: txType}
+	if data.LookupTxEntry(entry) {
+		return false
+	}
+	err := data.InsertTxEntry(entry)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"user":   uid,
+			"method": txType,
+			"table":  "txlog",
+		}).Warn("Could not insert user")
+		return false
+	}
+	return true
+}
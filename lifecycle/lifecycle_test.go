@@ -0,0 +1,132 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"github.com/cosmouser/mudwork/umapi"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// queued returns the users with a txType entry in the txlog and removes
+// the entries for the next test
+func queued(uids []string, txType string) []string {
+	got := []string{}
+	for _, j := range uids {
+		entry := &data.TxEntry{UniqueID: j, TxType: txType}
+		if data.LookupTxEntry(entry) {
+			got = append(got, j)
+			data.DeleteTxEntry(entry)
+		}
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestQueueRemovals(t *testing.T) {
+	saved := config.C
+	defer func() { config.C = saved }()
+	now := time.Now()
+	tests := []struct {
+		name        string
+		removeFrom  string
+		afterDays   int
+		unlicensed  map[string]int // days since each user lost their license
+		alreadyDone []string
+		txType      string
+		want        []string
+	}{
+		{"org", "org", 30, map[string]int{"aoki": 31, "bell": 29}, nil, "removeFromOrg", []string{"aoki"}},
+		{"default is org", "", 30, map[string]int{"aoki": 45}, nil, "removeFromOrg", []string{"aoki"}},
+		{"domain", "domain", 7, map[string]int{"aoki": 8, "bell": 10, "cruz": 1}, nil, "removeFromDomain", []string{"aoki", "bell"}},
+		{"already queued", "org", 30, map[string]int{"aoki": 31, "bell": 40}, []string{"bell"}, "removeFromOrg", []string{"aoki", "bell"}},
+		{"none due", "org", 90, map[string]int{"aoki": 89}, nil, "removeFromOrg", []string{}},
+	}
+	for _, tt := range tests {
+		config.C.Lifecycle = config.LifecycleConfig{RemoveAfterDays: tt.afterDays, RemoveFrom: tt.removeFrom}
+		uids := []string{}
+		for uid, days := range tt.unlicensed {
+			uids = append(uids, uid)
+			if err := data.InsertUnlicensed(uid, now.AddDate(0, 0, -days)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, j := range tt.alreadyDone {
+			if err := data.InsertTxEntry(&data.TxEntry{UniqueID: j, TxType: tt.txType}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n := queueRemovals(now)
+		got := queued(uids, tt.txType)
+		if n != len(tt.want)-len(tt.alreadyDone) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: queued %d %v, wanted %d %v\n", tt.name, n, got, len(tt.want)-len(tt.alreadyDone), tt.want)
+		}
+		for _, j := range uids {
+			data.DeleteUnlicensed(j)
+		}
+	}
+}
+
+func TestQueueNameUpdates(t *testing.T) {
+	saved, savedMembers, savedPeople := config.C, getUsersInGroup, getPeople
+	defer func() { config.C, getUsersInGroup, getPeople = saved, savedMembers, savedPeople }()
+	config.C.AdobeGroup = "Acrobat"
+	config.C.AccountType = umapi.FederatedID
+	config.C.AccountTypes = map[string]string{"visitor": umapi.AdobeID}
+	directory := map[string]ldapsearch.Result{
+		"jdoe":    {Person: &ldapsearch.Person{Uid: "jdoe", FirstName: "Jane", LastName: "Doe", Email: "jdoe@uni.edu"}},
+		"msmith":  {Person: &ldapsearch.Person{Uid: "msmith", FirstName: "Maria", LastName: "Smith-Lee", Email: "MSmith@uni.edu"}},
+		"visitor": {Person: &ldapsearch.Person{Uid: "visitor", FirstName: "Vic", LastName: "Tor", Email: "vic@example.com"}},
+		"noname":  {Person: &ldapsearch.Person{Uid: "noname", Email: "noname@uni.edu"}},
+		"gone":    {Err: errors.New("not found")},
+	}
+	getPeople = func(ctx context.Context, uids []string) (map[string]ldapsearch.Result, error) {
+		people := make(map[string]ldapsearch.Result)
+		for _, j := range uids {
+			people[j] = directory[j]
+		}
+		return people, nil
+	}
+	tests := []struct {
+		name    string
+		users   []string
+		members []umapi.User
+		err     error
+		want    []string
+	}{
+		{"unchanged", []string{"jdoe"}, []umapi.User{{Email: "jdoe@uni.edu", FirstName: "Jane", LastName: "Doe"}}, nil, []string{}},
+		{"first name", []string{"jdoe"}, []umapi.User{{Email: "jdoe@uni.edu", FirstName: "J", LastName: "Doe"}}, nil, []string{"jdoe"}},
+		{"last name, email case", []string{"msmith"}, []umapi.User{{Email: "msmith@uni.edu", FirstName: "Maria", LastName: "Smith"}}, nil, []string{"msmith"}},
+		{"not in group", []string{"jdoe"}, []umapi.User{{Email: "other@uni.edu", FirstName: "O", LastName: "Ther"}}, nil, []string{}},
+		{"adobe id", []string{"visitor"}, []umapi.User{{Email: "vic@example.com", FirstName: "Victor", LastName: "Tor"}}, nil, []string{}},
+		{"no name in directory", []string{"noname"}, []umapi.User{{Email: "noname@uni.edu", FirstName: "N", LastName: "N"}}, nil, []string{}},
+		{"not in directory", []string{"gone"}, nil, nil, []string{}},
+		{"adobe error", []string{"jdoe"}, nil, errors.New("503"), []string{}},
+	}
+	for _, tt := range tests {
+		getUsersInGroup = func(token *umapi.AccessResponse, group string) ([]umapi.User, error) {
+			if group != "Acrobat" {
+				t.Errorf("%s: asked for members of %s, wanted Acrobat\n", tt.name, group)
+			}
+			return tt.members, tt.err
+		}
+		for _, j := range tt.users {
+			if err := data.InsertUser(j); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n, err := queueNameUpdates(nil)
+		got := queued(tt.users, "update")
+		if err != tt.err || n != len(tt.want) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: queued %d %v, %v, wanted %d %v, %v\n", tt.name, n, got, err, len(tt.want), tt.want, tt.err)
+		}
+		for _, j := range tt.users {
+			data.DeleteUser(j)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Formats Configure knows how to write
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted stands in for secrets in log lines
+const Redacted = "[REDACTED]"
+
+func init() {
+	if err := Configure(config.C.Logging); err != nil {
+		log.WithFields(log.Fields{
+			"format": config.C.Logging.Format,
+			"level":  config.C.Logging.Level,
+			"file":   config.C.Logging.File,
+		}).Fatal(err)
+	}
+	SetSecret("ApiPass", config.C.ApiPass)
+	SetSecret("ApiClientSecret", config.C.ApiClientSecret)
+	SetSecret("LdapBindPassword", config.C.LdapBindPassword)
+	SetSecret("ActiveDirectory.BindPassword", config.C.ActiveDirectory.BindPassword)
+	SetSecret("AdminToken", config.C.AdminToken)
+	SetSecret("Enterprise.ClientSecret", config.C.Enterprise["ClientSecret"])
+	SetSecret("Enterprise.APIKey", config.C.Enterprise["APIKey"])
+	log.AddHook(redactHook{})
+}
+
+var (
+	fileMu sync.Mutex
+	// file is the log file opened by the last Configure, if any
+	file *os.File
+)
+
+// Configure applies c to the standard logger. Nothing is changed when
+// c is invalid or File can't be opened.
+func Configure(c config.LoggingConfig) error {
+	var formatter log.Formatter
+	switch c.Format {
+	case "", FormatText:
+		formatter = &log.TextFormatter{}
+	case FormatJSON:
+		formatter = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("logging: unknown format %q", c.Format)
+	}
+	level := log.InfoLevel
+	if c.Level != "" {
+		var err error
+		if level, err = log.ParseLevel(c.Level); err != nil {
+			return fmt.Errorf("logging: %v", err)
+		}
+	}
+	var out io.Writer = os.Stdout
+	var f *os.File
+	if c.File != "" {
+		var err error
+		f, err = os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return fmt.Errorf("logging: unable to open File: %v", err)
+		}
+		out = f
+	}
+	log.SetFormatter(formatter)
+	log.SetOutput(out)
+	log.SetLevel(level)
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	if file != nil {
+		file.Close()
+	}
+	file = f
+	return nil
+}
+
+// Level returns the name of the level currently logged
+func Level() string {
+	return log.GetLevel().String()
+}
+
+// SetLevel changes the level logged while Mudwork is running
+func SetLevel(name string) error {
+	level, err := log.ParseLevel(name)
+	if err != nil {
+		return fmt.Errorf("logging: %v", err)
+	}
+	if level != log.GetLevel() {
+		log.WithFields(log.Fields{
+			"from": log.GetLevel().String(),
+			"to":   level.String(),
+		}).Warn("Log level changed")
+	}
+	log.SetLevel(level)
+	return nil
+}
+
+// correlationKey holds a run's correlation ID in its context
+type correlationKey struct{}
+
+// WithCorrelation returns a copy of ctx carrying a new correlation ID for
+// the run it starts. The ID is the trace ID when the run is traced, so
+// log lines can be matched with spans.
+func WithCorrelation(ctx context.Context) context.Context {
+	id := ""
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		id = sc.TraceID().String()
+	} else {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID in ctx, or "" outside a run
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// From returns an entry for logging within the run in ctx, which tags
+// each line with its correlation_id
+func From(ctx context.Context) *log.Entry {
+	if id := CorrelationID(ctx); id != "" {
+		return log.WithField("correlation_id", id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+var (
+	secretsMu sync.RWMutex
+	// secrets maps a name, such as "ApiPass", to the value redacted
+	secrets = make(map[string]string)
+)
+
+// SetSecret has the current value of the named secret redacted from
+// every log line. Tokens are set again under the same name when they are
+// renewed, replacing the old value. Values shorter than four characters
+// would redact too much and are ignored.
+func SetSecret(name, value string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if len(value) < 4 {
+		delete(secrets, name)
+		return
+	}
+	secrets[name] = value
+}
+
+// patterns catch credentials Mudwork didn't set itself, such as tokens
+// in a response body that was logged
+var patterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9\-._~+/]+=*`),
+	regexp.MustCompile(`(?i)((?:access_token|refresh_token|jwt_token|client_secret|password)["']?\s*[:=]\s*["']?)[^"'&\s,}]+`),
+}
+
+// Redact replaces every secret in s with Redacted
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, v := range secrets {
+		s = strings.Replace(s, v, Redacted, -1)
+	}
+	secretsMu.RUnlock()
+	for _, p := range patterns {
+		s = p.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	return s
+}
+
+// secretField reports whether a field with this name always holds a
+// secret, whatever its value
+func secretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, j := range []string{"password", "secret", "token", "authorization", "apikey", "api_key"} {
+		if strings.Contains(name, j) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHook removes secrets from each entry before it is written
+type redactHook struct{}
+
+func (redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (redactHook) Fire(entry *log.Entry) error {
+	entry.Message = Redact(entry.Message)
+	// entry.Data may be shared with other entries, so it is copied
+	// rather than changed in place
+	data := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch value := v.(type) {
+		case string:
+			v = Redact(value)
+		case error:
+			if s := Redact(value.Error()); s != value.Error() {
+				v = s
+			}
+		}
+		if secretField(k) {
+			v = Redacted
+		}
+		data[k] = v
+	}
+	entry.Data = data
+	return nil
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/cosmouser/mudwork/admin"
 	"github.com/cosmouser/mudwork/config"
 	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/directory"
+	"github.com/cosmouser/mudwork/eligibility"
 	"github.com/cosmouser/mudwork/jamf"
 	"github.com/cosmouser/mudwork/ldapsearch"
+	"github.com/cosmouser/mudwork/lifecycle"
+	"github.com/cosmouser/mudwork/logging"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/cosmouser/mudwork/quota"
+	"github.com/cosmouser/mudwork/tracing"
 	"github.com/cosmouser/mudwork/umapi"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -33,17 +43,50 @@ var (
 		Name: "mudwork_db_users_rows",
 		Help: "Number of users with entitlements managed through Mudwork",
 	})
+	queueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mudwork_queue_depth",
+			Help: "Number of transactions waiting in the txlog",
+		},
+		[]string{"txtype"},
+	)
+	queueOldest = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mudwork_queue_oldest_age_seconds",
+		Help: "Age of the oldest transaction waiting in the txlog, 0 when it is empty",
+	})
+	transactionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mudwork_transactions_total",
+			Help: "Total number of transactions sent to Adobe by result, with Adobe's error or warning code",
+		},
+		[]string{"txtype", "result", "code"},
+	)
 )
 
 func init() {
-	// Register the counters and gauges with Prometheus's default registry.
-	prometheus.MustRegister(responsesTotal)
-	prometheus.MustRegister(dbSize)
-	prometheus.MustRegister(managedAccounts)
+	// Register the counters and gauges with Mudwork's registry.
+	metrics.MustRegister(responsesTotal)
+	metrics.MustRegister(dbSize)
+	metrics.MustRegister(managedAccounts)
+	metrics.MustRegister(queueDepth)
+	metrics.MustRegister(queueOldest)
+	metrics.MustRegister(transactionsTotal)
 }
 
 func main() {
 	fmt.Fprint(ioutil.Discard, "Copyright (c) 2018, Regents of the University of California. All rights reserved.")
+	if *config.FlagReview {
+		PrintReviews()
+		return
+	}
+	if *config.FlagApprove != "" {
+		Approve(*config.FlagApprove)
+		return
+	}
+	if *config.FlagSources != "" {
+		PrintSources(*config.FlagSources)
+		return
+	}
 	if *config.FlagNoInit {
 		log.Info("flag -noinit set, skipping token initialization")
 	} else {
@@ -53,22 +96,104 @@ func main() {
 		PrintGroups(umapi.Token)
 		return
 	}
+	if *config.FlagUser != "" {
+		PrintUser(umapi.Token, *config.FlagUser)
+		return
+	}
 	if *config.FlagTestMode {
 		log.Info("testOnly set to true")
 	}
-	// prometheus db size gauge
+	if config.C.AccountType != "" && !umapi.ValidAccountType(config.C.AccountType) {
+		log.WithFields(log.Fields{
+			"account_type": config.C.AccountType,
+		}).Fatal("Unknown AccountType in config")
+	}
+	for uid, accountType := range config.C.AccountTypes {
+		if !umapi.ValidAccountType(accountType) {
+			log.WithFields(log.Fields{
+				"user":         uid,
+				"account_type": accountType,
+			}).Fatal("Unknown account type in AccountTypes")
+		}
+	}
+	if config.C.JamfAuth != "" && !jamf.ValidAuth(config.C.JamfAuth) {
+		log.WithFields(log.Fields{
+			"jamf_auth": config.C.JamfAuth,
+		}).Fatal("Unknown JamfAuth in config")
+	}
+	if !quota.ValidAction(config.C.Quota.Action) {
+		log.WithFields(log.Fields{
+			"action": config.C.Quota.Action,
+		}).Fatal("Unknown Quota.Action in config")
+	}
+	if !quota.ValidPriority(config.C.Quota.Priority) {
+		log.WithFields(log.Fields{
+			"priority": config.C.Quota.Priority,
+		}).Fatal("Unknown Quota.Priority in config")
+	}
+	for _, j := range config.C.Directories {
+		if !directory.Valid(j) {
+			log.WithFields(log.Fields{
+				"directory": j,
+			}).Fatal("Unknown directory in Directories")
+		}
+	}
+	if !tracing.ValidExporter(config.C.Tracing.Exporter) {
+		log.WithFields(log.Fields{
+			"exporter": config.C.Tracing.Exporter,
+		}).Fatal("Unknown Tracing.Exporter in config")
+	}
+	shutdown, err := tracing.Init()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"exporter": config.C.Tracing.Exporter,
+		}).Fatal(err)
+	}
+	defer shutdown(context.Background())
+	// prometheus db gauges
 	go func() {
 		for {
 			dbSize.Set(data.GetDBSize())
 			managedAccounts.Set(float64(len(data.GetUsers())))
+			observeQueue(time.Now())
 			time.Sleep(time.Second * 60)
 		}
 	}()
-	msgs := make(chan int)
+	// a pending signal is enough since the queue itself is in the db
+	msgs := make(chan int, 1)
 	go worker(msgs)
+	if lifecycle.Enabled() {
+		go func() {
+			for {
+				if queued := lifecycle.Run(umapi.Token); queued > 0 {
+					select {
+					case msgs <- queued:
+					default:
+					}
+				}
+				time.Sleep(lifecycle.Interval())
+			}
+		}()
+	}
+	if jamf.GracePeriod() > 0 {
+		// users who left the JSS between webhooks still lose their
+		// license once the grace period is over
+		go func() {
+			for {
+				time.Sleep(time.Minute)
+				if queued := jamf.QueueExpiredRemovals(context.Background(), time.Now()); queued > 0 {
+					select {
+					case msgs <- queued:
+					default:
+					}
+				}
+			}
+		}()
+	}
 	handleWebhook := jamf.MakeWebhookHandler(msgs)
 	http.HandleFunc("/mudwork", handleWebhook)
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", metrics.Handler())
+	http.Handle("/admin/", admin.Handler())
 	http.ListenAndServe(fmt.Sprintf(":%d", *config.FlagPort), nil)
 }
 
@@ -81,68 +206,372 @@ func PrintGroups(token *umapi.AccessResponse) {
 		log.Printf("%+v", j)
 	}
 }
+
+// PrintUser prints a user's Adobe account and whether it is in the
+// configured AdobeGroup
+func PrintUser(token *umapi.AccessResponse, userString string) {
+	user, err := umapi.GetUser(token, userString)
+	if err != nil {
+		if se, ok := err.(*umapi.StatusError); ok && se.StatusCode == 404 {
+			log.WithFields(log.Fields{
+				"user": userString,
+			}).Info("User is not in the Adobe org")
+			return
+		}
+		panic(err)
+	}
+	log.Printf("%+v", *user)
+	log.WithFields(log.Fields{
+		"user":     userString,
+		"group":    config.C.AdobeGroup,
+		"licensed": user.InGroup(config.C.AdobeGroup),
+	}).Info("Group membership")
+	person, err := directory.GetPerson(context.Background(), userString)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"user":  userString,
+			"error": err,
+		}).Info("User is not in the directory")
+		return
+	}
+	log.WithFields(log.Fields{
+		"user":   userString,
+		"source": person.Source,
+		"email":  person.Email,
+		"name":   person.FirstName + " " + person.LastName,
+	}).Info("Directory entry")
+}
+
+// PrintSources prints the devices in the JSS that justify a user's
+// license, as recorded by the last sync
+func PrintSources(uid string) {
+	sources := data.GetLicenseSources(uid)
+	if len(sources) == 0 {
+		log.WithFields(log.Fields{
+			"user": uid,
+		}).Info("No devices license this user")
+		return
+	}
+	for _, j := range sources {
+		log.WithFields(log.Fields{
+			"user":          j.UniqueID,
+			"kind":          j.Kind,
+			"device_id":     j.DeviceID,
+			"device_name":   j.DeviceName,
+			"serial_number": j.SerialNumber,
+			"source":        j.Source,
+		}).Info("License source")
+	}
+}
+
+// PrintReviews prints the users held for eligibility review and the
+// transactions dropped because the directory has no entry for the user
+func PrintReviews() {
+	for _, j := range data.GetReviews(data.ReviewPending) {
+		log.WithFields(log.Fields{
+			"user":    j.UniqueID,
+			"txtype":  j.TxType,
+			"reason":  j.Reason,
+			"created": j.Created,
+		}).Info("Held for review")
+	}
+	for _, j := range data.GetReviews(data.ReviewQuota) {
+		log.WithFields(log.Fields{
+			"user":    j.UniqueID,
+			"txtype":  j.TxType,
+			"reason":  j.Reason,
+			"created": j.Created,
+		}).Info("Held for license quota")
+	}
+	for _, j := range data.GetReviews(data.ReviewDeadLetter) {
+		log.WithFields(log.Fields{
+			"user":    j.UniqueID,
+			"txtype":  j.TxType,
+			"reason":  j.Reason,
+			"created": j.Created,
+		}).Info("Dead letter")
+	}
+}
+
+// Approve lets a held user through the eligibility rules and queues
+// their license for the next run
+func Approve(uid string) {
+	r := data.LookupReview(uid, "add")
+	if r == nil {
+		log.WithFields(log.Fields{
+			"user": uid,
+		}).Fatal("User is not held for review")
+	}
+	err := data.SetReviewStatus(uid, "add", data.ReviewApproved)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entry := &data.TxEntry{UniqueID: uid, TxType: "add"}
+	if !data.LookupTxEntry(entry) {
+		err = data.InsertTxEntry(entry)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.WithFields(log.Fields{
+		"user": uid,
+	}).Info("Approved and queued")
+}
+
+// deadLetter takes an entry the directory can't support off the txlog
+// and records why
+func deadLetter(ctx context.Context, j data.TxEntry, reason string) {
+	err := data.InsertReview(&data.Review{
+		UniqueID: j.UniqueID,
+		TxType:   j.TxType,
+		Reason:   reason,
+		Status:   data.ReviewDeadLetter,
+		Created:  time.Now(),
+	})
+	if err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"uid":   j.UniqueID,
+			"table": "review",
+		}).Warn(err)
+	}
+	err = data.DeleteTxEntry(&j)
+	if err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"uid":    j.UniqueID,
+			"txtype": j.TxType,
+		}).Warn("unable to remove TxEntry")
+	}
+	logging.From(ctx).WithFields(log.Fields{
+		"uid":    j.UniqueID,
+		"txtype": j.TxType,
+		"reason": reason,
+	}).Warn("Unable to lookup user in Ldap, removing from transaction log")
+	writeBack(ctx, j, jamf.Failed(reason))
+}
+
+// holdIneligible checks an add against the eligibility rules. An
+// ineligible user is taken off the txlog and recorded as rejected or
+// held for review, with the reason. Rejected users are queued again by
+// each sync, so they are checked again in case they became eligible; a
+// repeat of the same decision keeps the time it was first made and
+// isn't written back to the JSS again.
+func holdIneligible(ctx context.Context, j data.TxEntry, person *ldapsearch.Person) bool {
+	prev := data.LookupReview(j.UniqueID, j.TxType)
+	if prev != nil && prev.Status == data.ReviewApproved {
+		return false
+	}
+	eligible, reason := eligibility.Check(person)
+	if eligible {
+		return false
+	}
+	status := data.ReviewRejected
+	if eligibility.Review() {
+		status = data.ReviewPending
+	}
+	repeat := prev != nil && prev.Status == status
+	created := time.Now()
+	if repeat {
+		created = prev.Created
+	}
+	err := data.InsertReview(&data.Review{
+		UniqueID: j.UniqueID,
+		TxType:   j.TxType,
+		Reason:   reason,
+		Status:   status,
+		Created:  created,
+	})
+	if err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"uid":   j.UniqueID,
+			"table": "review",
+		}).Warn(err)
+	}
+	err = data.DeleteTxEntry(&j)
+	if err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"uid":    j.UniqueID,
+			"txtype": j.TxType,
+		}).Warn("unable to remove TxEntry")
+	}
+	logging.From(ctx).WithFields(log.Fields{
+		"uid":    j.UniqueID,
+		"txtype": j.TxType,
+		"reason": reason,
+		"status": status,
+	}).Warn("User is not eligible, removing from transaction log")
+	if repeat {
+		// the JSS already shows this decision
+		return true
+	}
+	if status == data.ReviewPending {
+		writeBack(ctx, j, jamf.StatusPending)
+	} else {
+		writeBack(ctx, j, jamf.Failed("ineligible"))
+	}
+	return true
+}
+
+// limitQuota holds or rejects the adds in a batch that would take the
+// AdobeGroup past the quota threshold. When the quota can't be read the
+// batch goes ahead and Adobe has the final say.
+func limitQuota(ctx context.Context, entries []data.TxEntry, people map[string]ldapsearch.Result) []data.TxEntry {
+	var adds int
+	for _, j := range entries {
+		if j.TxType == "add" {
+			adds++
+		}
+	}
+	if adds == 0 {
+		return entries
+	}
+	usage, err := quota.Fetch(umapi.Token)
+	if err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"error": err,
+		}).Warn("Unable to check license quota")
+		return entries
+	}
+	send, over := quota.Limit(usage, entries, people)
+	for _, j := range over {
+		if quota.Hold(j) == data.ReviewQuota {
+			writeBack(ctx, j, jamf.StatusPending)
+		} else {
+			writeBack(ctx, j, jamf.Failed("quota"))
+		}
+	}
+	return send
+}
 func worker(messenger chan int) {
 	for i := range messenger {
-		log.WithFields(log.Fields{
+		ctx, span := tracing.Start(context.Background(), "queue",
+			attribute.Int("queue.num_changes", i))
+		ctx = logging.WithCorrelation(ctx)
+		logging.From(ctx).WithFields(log.Fields{
 			"num_changes": i,
 		}).Info("changes received")
-		processQueue()
+		// keep the queue intact and try again later while the
+		// directory can't be reached
+		for wait := time.Second * 30; ; wait *= 2 {
+			err := processQueue(ctx)
+			if err == nil {
+				break
+			}
+			if wait > time.Minute*30 {
+				wait = time.Minute * 30
+			}
+			logging.From(ctx).WithFields(log.Fields{
+				"error": err,
+				"retry": wait.String(),
+			}).Warn("Directory unavailable, leaving transactions queued")
+			span.AddEvent("directory unavailable", trace.WithAttributes(
+				attribute.String("retry.wait", wait.String())))
+			time.Sleep(wait)
+		}
+		if quota.Enabled() && quota.Release(ctx, umapi.Token) > 0 {
+			// seats freed by this run go to adds held for the quota
+			if err := processQueue(ctx); err != nil {
+				logging.From(ctx).WithFields(log.Fields{
+					"error": err,
+				}).Warn("Directory unavailable, leaving transactions queued")
+			}
+		}
+		span.End()
+	}
+}
+
+// processQueue sends queued transactions to Adobe in batches until the
+// txlog is empty. It returns an error, leaving the batch queued, when
+// the directory is unavailable.
+func processQueue(ctx context.Context) error {
+	for {
+		more, err := processBatch(ctx)
+		if err != nil || !more {
+			return err
+		}
 	}
 }
-func processQueue() {
+
+// processBatch sends the next batch in the txlog to Adobe, in a span of
+// its own. more reports whether entries may be left behind it.
+func processBatch(parent context.Context) (more bool, err error) {
+	ctx, span := tracing.Start(parent, "queue.batch")
+	defer func() { tracing.End(span, err) }()
 	txEntries, err := data.GetTxEntries()
 	approvedTxEntries := []data.TxEntry{}
 	if err != nil {
-		log.WithFields(log.Fields{
+		logging.From(ctx).WithFields(log.Fields{
 			"function": "processQueue",
 			"table":    "txlog",
 		}).Fatal("Could not connect to database")
 	}
+	uids := make([]string, len(txEntries))
+	for i, j := range txEntries {
+		uids[i] = j.UniqueID
+	}
+	people, err := directory.GetPeople(ctx, uids)
+	if err != nil {
+		return false, err
+	}
 	for _, j := range txEntries {
-		person, err := ldapsearch.GetPerson(j.UniqueID)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"user":     j.UniqueID,
-				"function": "processQueue",
-			}).Warn("Ldap search failed")
-		}
-		if len(person.FirstName) == 0 && j.TxType == "add" {
-			err = data.DeleteTxEntry(&j)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"uid":    j.UniqueID,
-					"txtype": j.TxType,
-				}).Warn("unable to remove TxEntry")
-			}
-			log.WithFields(log.Fields{
-				"uid":    j.UniqueID,
-				"txtype": j.TxType,
-			}).Warn("Unable to lookup user in Ldap, removing from transaction log")
-		} else {
+		result := people[j.UniqueID]
+		needsPerson := j.TxType == "add" || j.TxType == "update"
+		switch {
+		case needsPerson && result.Err != nil:
+			deadLetter(ctx, j, result.Err.Error())
+		case needsPerson && len(result.Person.FirstName) == 0:
+			deadLetter(ctx, j, "no first name in directory")
+		case j.TxType == "add" && holdIneligible(ctx, j, result.Person):
+			// rejected or held for review
+		default:
 			approvedTxEntries = append(approvedTxEntries, j)
 		}
 	}
 
-	// break recursion if no more entries
+	if quota.Enabled() {
+		approvedTxEntries = limitQuota(ctx, approvedTxEntries, people)
+	}
+
+	// stop once there are no more entries
 	resultsReturned := len(approvedTxEntries)
+	span.SetAttributes(
+		attribute.Int("queue.entries", len(txEntries)),
+		attribute.Int("queue.approved", resultsReturned))
 	if resultsReturned < 1 {
-		return
+		// every entry in the batch was held or dropped, which took them
+		// all off the txlog, so there may be more behind them. An empty
+		// batch means the txlog is empty.
+		return len(txEntries) > 0, nil
 	} else {
-		if resultsReturned < 6 {
-			log.WithFields(log.Fields{
-				"txEntries": approvedTxEntries,
-			}).Info("Processing transactions")
+		batch := make([]string, resultsReturned)
+		for i, j := range approvedTxEntries {
+			batch[i] = j.TxType + ":" + j.UniqueID
 		}
+		logging.From(ctx).WithFields(log.Fields{
+			"num_entries": resultsReturned,
+		}).Info("Processing transactions")
+		logging.From(ctx).WithFields(log.Fields{
+			"entries": batch,
+		}).Debug("Transactions in batch")
 	}
 	items := make([]umapi.Item, resultsReturned)
 	for i, j := range approvedTxEntries {
 		switch j.TxType {
 		case "add":
-			items[i] = umapi.GenAddItem(j.UniqueID, config.C.AdobeGroup)
+			logging.From(ctx).WithFields(log.Fields{
+				"uid":    j.UniqueID,
+				"source": people[j.UniqueID].Person.Source,
+			}).Info("Adding user")
+			items[i] = umapi.GenAddItem(people[j.UniqueID].Person, config.C.AdobeGroup)
 		case "remove":
 			items[i] = umapi.GenRemoveItem(j.UniqueID, config.C.AdobeGroup)
+		case "update":
+			items[i] = umapi.GenUpdateItem(people[j.UniqueID].Person)
+		case "removeFromOrg":
+			items[i] = umapi.GenRemoveFromOrgItem(j.UniqueID, config.C.Lifecycle.DeleteAccount)
+		case "removeFromDomain":
+			items[i] = umapi.GenRemoveFromDomainItem(j.UniqueID)
 		default:
-			log.WithFields(log.Fields{
+			logging.From(ctx).WithFields(log.Fields{
 				"function": "processQueue",
 				"txtype":   j.TxType,
 				"uid":      j.UniqueID,
@@ -151,75 +580,68 @@ func processQueue() {
 	}
 	requestBody, err := json.Marshal(items)
 	if err != nil {
-		log.Fatal("Unable to marshal requestBody")
+		logging.From(ctx).Fatal("Unable to marshal requestBody")
 	}
-	var responseCode, retryAmount, numRequests int
+	var responseCode, numRequests int
 	var actionResponse umapi.ActionResponse
+	actionCtx, actionSpan := tracing.Start(ctx, "umapi.action",
+		attribute.Int("umapi.items", len(items)))
 	for responseCode != 200 {
-		numRequests++
-		response, err := umapi.SendRequest(string(requestBody), umapi.Token)
+		response, err := umapi.Retry.Do(actionCtx, "action", func() (*http.Response, error) {
+			numRequests++
+			response, err := umapi.SendRequest(string(requestBody), umapi.Token)
+			if err == nil {
+				responsesTotal.With(prometheus.Labels{"status": strconv.Itoa(response.StatusCode)}).Inc()
+			}
+			return response, err
+		})
 		if err != nil {
-			log.WithFields(log.Fields{
+			// leave the entries in the txlog so the next change retries them
+			logging.From(ctx).WithFields(log.Fields{
 				"request_length": len(requestBody),
-				"token_type":     umapi.Token.TokenType,
-				"token_exp":      umapi.Token.ExpiresIn,
-			}).Fatal(err)
+				"num_requests":   numRequests,
+			}).Error(err)
+			actionSpan.SetAttributes(attribute.Int("umapi.requests", numRequests))
+			tracing.End(actionSpan, err)
+			return false, nil
 		}
-		statusCodeString := strconv.Itoa(response.StatusCode)
-		responsesTotal.With(prometheus.Labels{"status": statusCodeString}).Inc()
 		switch response.StatusCode {
 		case 200:
 			defer response.Body.Close()
 			output, err := ioutil.ReadAll(response.Body)
 			if err != nil {
-				log.WithFields(log.Fields{
+				logging.From(ctx).WithFields(log.Fields{
 					"code": response.StatusCode,
 					"step": "load response body",
 				}).Fatal(err)
 			}
 			err = json.Unmarshal(output, &actionResponse)
 			if err != nil {
-				log.WithFields(log.Fields{
+				logging.From(ctx).WithFields(log.Fields{
 					"code": response.StatusCode,
 					"step": "unmarshal action response",
 				}).Fatal(err)
 			}
 			responseCode = response.StatusCode
 		case 400:
-			log.WithFields(log.Fields{
+			logging.From(ctx).WithFields(log.Fields{
 				"request": "action",
 				"code":    response.StatusCode,
 			}).Fatal("Bad request or Service Account Integration Certificate has expired.")
 		case 401:
-			log.WithFields(log.Fields{
+			response.Body.Close()
+			logging.From(ctx).WithFields(log.Fields{
 				"request": "action",
 				"code":    response.StatusCode,
 			}).Warn("Possible causes are invalid token, expired token or invalid organization.")
 			umapi.Token.Renew()
 		case 403:
-			log.WithFields(log.Fields{
+			logging.From(ctx).WithFields(log.Fields{
 				"request": "action",
 				"code":    response.StatusCode,
 			}).Fatal("Missing API key or API key is not permitted access.")
-		case 429:
-			retryAfterString := response.Header.Get("Retry-After")
-			retryAfterInt, err := strconv.Atoi(retryAfterString)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"request": "action",
-					"code":    response.StatusCode,
-				}).Fatal(err)
-			}
-			// add an additional second for good measure
-			retryAmount += retryAfterInt + 1
-			log.WithFields(log.Fields{
-				"request": "action",
-				"code":    response.StatusCode,
-				"retry":   retryAmount,
-			}).Warn("Too many requests")
-			time.Sleep(time.Duration(retryAmount) * time.Second)
 		default:
-			log.WithFields(log.Fields{
+			logging.From(ctx).WithFields(log.Fields{
 				"request": "action",
 				"code":    response.StatusCode,
 			}).Fatal("Unhandled response code. Mudwork config may be incorrect.")
@@ -227,135 +649,96 @@ func processQueue() {
 
 	}
 	if numRequests > 1 {
-		log.WithFields(log.Fields{
+		logging.From(ctx).WithFields(log.Fields{
 			"num_requests": numRequests,
 		}).Warn("Response code 200 required more than one request")
 	}
-	log.WithFields(log.Fields{
+	actionSpan.SetAttributes(
+		attribute.Int("http.status_code", responseCode),
+		attribute.Int("umapi.requests", numRequests),
+		attribute.String("umapi.result", actionResponse.Result),
+		attribute.Int("umapi.completed", actionResponse.Completed),
+		attribute.Int("umapi.not_completed", actionResponse.NotCompleted),
+		attribute.StringSlice("adobe.request_ids", requestIDs(actionResponse)))
+	actionSpan.End()
+	logging.From(ctx).WithFields(log.Fields{
 		"completed":           actionResponse.Completed,
 		"notCompleted":        actionResponse.NotCompleted,
 		"completedInTestMode": actionResponse.CompletedInTestMode,
+		"request_ids":         requestIDs(actionResponse),
 	}).Info("Results")
 	switch actionResponse.Result {
 	case "success":
 		// delete tx entries from txlog, add to users table
-		for _, j := range approvedTxEntries {
+		for index, j := range approvedTxEntries {
 			err = data.DeleteTxEntry(&j)
 			if err != nil {
-				log.WithFields(log.Fields{
+				logging.From(ctx).WithFields(log.Fields{
 					"table":  "txlog",
 					"user":   j.UniqueID,
 					"txtype": j.TxType,
 				}).Fatal("Unable to delete row")
 			}
-			if *config.FlagTestMode {
-				log.Info("Test mode enabled. Skipping Users table modifications.")
-			} else {
-				switch j.TxType {
-				case "add":
-					err = data.InsertUser(j.UniqueID)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"table": "users",
-							"user":  j.UniqueID,
-						}).Fatal("Unable to insert row")
-					}
-				case "remove":
-					err = data.DeleteUser(j.UniqueID)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"table": "users",
-							"user":  j.UniqueID,
-						}).Fatal("Unable to delete row")
-					}
-				default:
-					// fatal unexpected result
-					log.WithFields(log.Fields{
-						"object":   "TxType",
-						"UniqueID": j.UniqueID,
-						"TxType":   j.TxType,
-					}).Fatal("Unexpected TxType value")
-				}
-			}
-
+			applyTxEntry(ctx, j, items[index])
+			countTransaction(j, "applied", "")
+			writeBack(ctx, j, completed(j))
 		}
 	case "partial":
 		// delete tx entries from txlog, add succeeded to users table
 		// warn failed
-		errorsMap := make(map[string]int)
+		// errors and warnings carry the index of the item they refer to,
+		// which matches the entry's position in approvedTxEntries
+		errorsMap := make(map[int]int)
 		respErrors := []umapi.ActionResponseError{}
 		if actionResponse.Errors != nil {
 			respErrors = make([]umapi.ActionResponseError, len(*actionResponse.Errors))
 			for i, j := range *actionResponse.Errors {
 				respErrors[i] = j
-				userName := respErrors[i].User //[0:strings.Index(respErrors[i].User, "@")]
-				errorsMap[userName] = i
+				errorsMap[j.Index] = i
 			}
 		}
-		warningsMap := make(map[string]int)
+		warningsMap := make(map[int]int)
 		respWarnings := []umapi.ActionResponseWarning{}
 		if actionResponse.Warnings != nil {
 			respWarnings = make([]umapi.ActionResponseWarning, len(*actionResponse.Warnings))
 			for i, j := range *actionResponse.Warnings {
 				respWarnings[i] = j
-				userName := respWarnings[i].User //[0:strings.Index(respWarnings[i].User, "@")]
-				warningsMap[userName] = i
+				warningsMap[j.Index] = i
 			}
 		}
-		for _, j := range approvedTxEntries {
-			jUniqueID := fmt.Sprintf("%s@%s", j.UniqueID, config.C.Enterprise["Domain"])
+		for index, j := range approvedTxEntries {
 			err = data.DeleteTxEntry(&j)
 			if err != nil {
-				log.WithFields(log.Fields{
+				logging.From(ctx).WithFields(log.Fields{
 					"table":  "txlog",
 					"user":   j.UniqueID,
 					"txtype": j.TxType,
 				}).Fatal("Unable to delete row")
 			}
-			if elem, ok := errorsMap[jUniqueID]; ok {
-				log.WithFields(log.Fields{
+			if elem, ok := errorsMap[index]; ok {
+				logging.From(ctx).WithFields(log.Fields{
 					"error_code": respErrors[elem].ErrorCode,
 					"user":       respErrors[elem].User,
 					"message":    respErrors[elem].Message,
+					"request_id": respErrors[elem].RequestID,
 				}).Warn("Action failed")
+				countTransaction(j, "failed", respErrors[elem].ErrorCode)
+				writeBack(ctx, j, jamf.Failed(respErrors[elem].ErrorCode))
 				continue
 			}
-			if elem, ok := warningsMap[jUniqueID]; ok {
-				log.WithFields(log.Fields{
+			if elem, ok := warningsMap[index]; ok {
+				logging.From(ctx).WithFields(log.Fields{
 					"error_code": respWarnings[elem].WarningCode,
 					"user":       respWarnings[elem].User,
 					"message":    respWarnings[elem].Message,
+					"request_id": respWarnings[elem].RequestID,
 				}).Warn("Action returned warning")
-			}
-			if *config.FlagTestMode {
-				log.Info("Test mode enabled. Skipping Users table modifications.")
+				countTransaction(j, "warned", respWarnings[elem].WarningCode)
 			} else {
-				switch j.TxType {
-				case "add":
-					err = data.InsertUser(j.UniqueID)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"table": "users",
-							"user":  j.UniqueID,
-						}).Fatal("Unable to insert row")
-					}
-				case "remove":
-					err = data.DeleteUser(j.UniqueID)
-					if err != nil {
-						log.WithFields(log.Fields{
-							"table": "users",
-							"user":  j.UniqueID,
-						}).Fatal("Unable to delete row")
-					}
-				default:
-					// fatal unexpected result
-					log.WithFields(log.Fields{
-						"object":   "TxType",
-						"UniqueID": j.UniqueID,
-						"TxType":   j.TxType,
-					}).Fatal("Unexpected TxType value")
-				}
+				countTransaction(j, "applied", "")
 			}
+			applyTxEntry(ctx, j, items[index])
+			writeBack(ctx, j, completed(j))
 		}
 
 	case "error":
@@ -363,7 +746,7 @@ func processQueue() {
 		for _, j := range approvedTxEntries {
 			err = data.DeleteTxEntry(&j)
 			if err != nil {
-				log.WithFields(log.Fields{
+				logging.From(ctx).WithFields(log.Fields{
 					"table":  "txlog",
 					"user":   j.UniqueID,
 					"txtype": j.TxType,
@@ -371,19 +754,142 @@ func processQueue() {
 			}
 		}
 		for _, j := range *actionResponse.Errors {
-			log.WithFields(log.Fields{
+			logging.From(ctx).WithFields(log.Fields{
 				"error_code": j.ErrorCode,
 				"user":       j.User,
 				"message":    j.Message,
+				"request_id": j.RequestID,
 			}).Warn("Action failed")
+			if j.Index >= 0 && j.Index < len(approvedTxEntries) {
+				countTransaction(approvedTxEntries[j.Index], "failed", j.ErrorCode)
+				writeBack(ctx, approvedTxEntries[j.Index], jamf.Failed(j.ErrorCode))
+			}
 		}
 	default:
 		// fatal unexpected result
-		log.WithFields(log.Fields{
+		logging.From(ctx).WithFields(log.Fields{
 			"object": "ActionResponse",
 			"result": actionResponse.Result,
 		}).Fatal("Unexpected result value")
 	}
 	// check for more entries
-	processQueue()
+	return true, nil
+}
+
+// writeBack reports the status of an add or remove to the JSS. Nothing
+// is written in test mode, where Adobe doesn't apply the change.
+func writeBack(ctx context.Context, j data.TxEntry, status string) {
+	if *config.FlagTestMode || (j.TxType != "add" && j.TxType != "remove") {
+		return
+	}
+	jamf.WriteBack(ctx, j.UniqueID, status)
+}
+
+// requestIDs returns the Adobe request IDs of every error and warning in
+// r, so the span for an action can be matched up with Adobe's records
+func requestIDs(r umapi.ActionResponse) []string {
+	ids := []string{}
+	if r.Errors != nil {
+		for _, j := range *r.Errors {
+			ids = append(ids, j.RequestID)
+		}
+	}
+	if r.Warnings != nil {
+		for _, j := range *r.Warnings {
+			ids = append(ids, j.RequestID)
+		}
+	}
+	return ids
+}
+
+// countTransaction records the outcome of sending j to Adobe. result is
+// "applied", "warned" (applied with a warning) or "failed", and code is
+// Adobe's warning or error code.
+func countTransaction(j data.TxEntry, result, code string) {
+	transactionsTotal.With(prometheus.Labels{
+		"txtype": j.TxType,
+		"result": result,
+		"code":   code,
+	}).Inc()
+}
+
+// observeQueue sets the queue gauges from the txlog
+func observeQueue(now time.Time) {
+	queueDepth.Reset()
+	for txType, n := range data.GetQueueDepth() {
+		queueDepth.With(prometheus.Labels{"txtype": txType}).Set(float64(n))
+	}
+	if oldest, ok := data.GetOldestTxEntry(); ok {
+		queueOldest.Set(now.Sub(oldest).Seconds())
+	} else {
+		queueOldest.Set(0)
+	}
+}
+
+// completed is the status written once j has gone through
+func completed(j data.TxEntry) string {
+	if j.TxType == "remove" {
+		return jamf.StatusUnlicensed
+	}
+	return jamf.StatusLicensed
+}
+
+// applyTxEntry records a completed transaction in the users and
+// unlicensed tables. An add also records the identity in item, the
+// account it was sent for, which later actions address.
+func applyTxEntry(ctx context.Context, j data.TxEntry, item umapi.Item) {
+	if *config.FlagTestMode {
+		logging.From(ctx).Info("Test mode enabled. Skipping Users table modifications.")
+		return
+	}
+	var err error
+	switch j.TxType {
+	case "add":
+		err = data.InsertUser(j.UniqueID)
+		if err != nil {
+			logging.From(ctx).WithFields(log.Fields{
+				"table": "users",
+				"user":  j.UniqueID,
+			}).Fatal("Unable to insert row")
+		}
+		if err := data.SetIdentity(data.Identity{UniqueID: j.UniqueID, User: item.User, AdobeID: item.UseAdobeID}); err != nil {
+			logging.From(ctx).WithFields(log.Fields{
+				"table": "identities",
+				"user":  j.UniqueID,
+			}).Warn(err)
+		}
+		err = data.DeleteUnlicensed(j.UniqueID)
+	case "remove":
+		err = data.DeleteUser(j.UniqueID)
+		if err != nil {
+			logging.From(ctx).WithFields(log.Fields{
+				"table": "users",
+				"user":  j.UniqueID,
+			}).Fatal("Unable to delete row")
+		}
+		err = data.InsertUnlicensed(j.UniqueID, time.Now())
+	case "update":
+	case "removeFromOrg", "removeFromDomain":
+		// the account has left the org, so a new one may be created
+		if err := data.DeleteIdentity(j.UniqueID); err != nil {
+			logging.From(ctx).WithFields(log.Fields{
+				"table": "identities",
+				"user":  j.UniqueID,
+			}).Warn(err)
+		}
+		err = data.DeleteUnlicensed(j.UniqueID)
+	default:
+		// fatal unexpected result
+		logging.From(ctx).WithFields(log.Fields{
+			"object":   "TxType",
+			"UniqueID": j.UniqueID,
+			"TxType":   j.TxType,
+		}).Fatal("Unexpected TxType value")
+	}
+	if err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"table": "unlicensed",
+			"user":  j.UniqueID,
+		}).Warn(err)
+	}
 }
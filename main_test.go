@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/directory"
+	"github.com/cosmouser/mudwork/jamf"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"github.com/cosmouser/mudwork/tracing"
+	"github.com/cosmouser/mudwork/umapi"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// people is a directory with a result for everyone in it. Anyone else
+// is not found.
+type people map[string]ldapsearch.Result
+
+func (people) Name() string { return "people" }
+
+func (p people) GetPeople(ctx context.Context, uids []string) (map[string]ldapsearch.Result, error) {
+	results := make(map[string]ldapsearch.Result)
+	for _, j := range uids {
+		if r, ok := p[j]; ok {
+			results[j] = r
+		} else {
+			results[j] = ldapsearch.Result{Err: ldapsearch.ErrNotFound}
+		}
+	}
+	return results, nil
+}
+
+// down is a directory that can't be reached
+type down struct{ err error }
+
+func (down) Name() string { return "down" }
+
+func (d down) GetPeople(ctx context.Context, uids []string) (map[string]ldapsearch.Result, error) {
+	return nil, d.err
+}
+
+// attr returns the value of key in attrs
+func attr(attrs []attribute.KeyValue, key string) attribute.Value {
+	for _, j := range attrs {
+		if string(j.Key) == key {
+			return j.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestProcessQueueSpans(t *testing.T) {
+	var actions int
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/usermanagement/action/org" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if actions++; actions == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"completed": 2, "notCompleted": 0, "completedInTestMode": 0, "result": "success"}`)
+	}))
+	defer ts.Close()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	saved, savedDirectory, savedRetry, savedToken := config.C, directory.Default, umapi.Retry, umapi.Token
+	savedTransport, savedTracer := http.DefaultTransport, tracing.Tracer
+	defer func() {
+		config.C, directory.Default, umapi.Retry, umapi.Token = saved, savedDirectory, savedRetry, savedToken
+		http.DefaultTransport, tracing.Tracer = savedTransport, savedTracer
+	}()
+	config.C.Server = map[string]string{"Host": strings.TrimPrefix(ts.URL, "https://"), "Endpoint": "/v2/usermanagement"}
+	config.C.Enterprise = map[string]string{"OrgID": "org", "Domain": "uni.edu"}
+	config.C.Quota = config.QuotaConfig{}
+	config.C.Lifecycle = config.LifecycleConfig{}
+	directory.Default = directory.Chain{people{
+		"trupdate": {Person: &ldapsearch.Person{Uid: "trupdate", FirstName: "Tracy", LastName: "Update", Email: "trupdate@uni.edu"}},
+	}}
+	umapi.Retry = umapi.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	umapi.Token = &umapi.AccessResponse{AccessToken: "token"}
+	// SendRequest uses the default transport, which doesn't trust ts
+	http.DefaultTransport = ts.Client().Transport
+	tracing.Tracer = provider.Tracer("test")
+	entries := []data.TxEntry{{UniqueID: "trupdate", TxType: "update"}, {UniqueID: "trleave", TxType: "removeFromOrg"}}
+	if err := data.InsertTxEntries(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := tracing.Start(context.Background(), "queue")
+	if err := processQueue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+	for _, j := range entries {
+		if data.LookupTxEntry(&j) {
+			t.Errorf("%+v is still queued\n", j)
+		}
+	}
+
+	var batches, action []tracetest.SpanStub
+	for _, j := range exporter.GetSpans() {
+		switch j.Name {
+		case "queue.batch":
+			batches = append(batches, j)
+		case "umapi.action":
+			action = append(action, j)
+		}
+	}
+	// the second batch finds the txlog empty
+	if len(batches) != 2 || len(action) != 1 {
+		t.Fatalf("got %d batches and %d actions, wanted 2 and 1\n", len(batches), len(action))
+	}
+	for i, want := range []int64{2, 0} {
+		if batches[i].Parent.SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("batch %d is not a child of the queue span\n", i)
+		}
+		if got := attr(batches[i].Attributes, "queue.entries").AsInt64(); got != want {
+			t.Errorf("batch %d got %d entries, wanted %d\n", i, got, want)
+		}
+	}
+	if got := attr(batches[0].Attributes, "queue.approved").AsInt64(); got != 2 {
+		t.Errorf("got %d approved, wanted 2\n", got)
+	}
+	if action[0].Parent.SpanID() != batches[0].SpanContext.SpanID() {
+		t.Error("umapi.action is not a child of the first batch")
+	}
+	for key, want := range map[string]int64{"umapi.items": 2, "umapi.requests": 2, "http.status_code": 200, "umapi.completed": 2, "umapi.not_completed": 0} {
+		if got := attr(action[0].Attributes, key).AsInt64(); got != want {
+			t.Errorf("got %s %d, wanted %d\n", key, got, want)
+		}
+	}
+	if got := attr(action[0].Attributes, "umapi.result").AsString(); got != "success" {
+		t.Errorf("got result %q, wanted success\n", got)
+	}
+	events := action[0].Events
+	if len(events) != 1 || events[0].Name != "retry" ||
+		attr(events[0].Attributes, "attempt").AsInt64() != 1 ||
+		attr(events[0].Attributes, "http.status_code").AsInt64() != 503 {
+		t.Errorf("got events %+v, wanted one retry after a 503\n", events)
+	}
+}
+
+func TestProcessQueueUnavailable(t *testing.T) {
+	saved, savedDirectory := config.C, directory.Default
+	defer func() { config.C, directory.Default = saved, savedDirectory }()
+	config.C.Quota = config.QuotaConfig{}
+	unavailable := &ldapsearch.UnavailableError{Err: errors.New("connection refused")}
+	directory.Default = directory.Chain{down{unavailable}}
+	entries := []data.TxEntry{{UniqueID: "trdown", TxType: "add"}, {UniqueID: "trdowntoo", TxType: "update"}}
+	if err := data.InsertTxEntries(entries); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, j := range entries {
+			data.DeleteTxEntry(&j)
+		}
+	}()
+
+	if err := processQueue(context.Background()); err != unavailable {
+		t.Errorf("got %v, wanted the UnavailableError\n", err)
+	}
+	for _, j := range entries {
+		if !data.LookupTxEntry(&j) {
+			t.Errorf("%+v was taken off the txlog\n", j)
+		}
+		if r := data.LookupReview(j.UniqueID, j.TxType); r != nil {
+			t.Errorf("got review %+v for %+v, wanted none\n", r, j)
+		}
+	}
+}
+
+func TestProcessQueueDeadLetter(t *testing.T) {
+	saved, savedDirectory := config.C, directory.Default
+	defer func() { config.C, directory.Default = saved, savedDirectory }()
+	config.C.Quota = config.QuotaConfig{}
+	config.C.ExtensionAttributeID = 0
+	directory.Default = directory.Chain{people{
+		"trtwin": {Err: ldapsearch.ErrMultipleEntries},
+	}}
+	tests := []struct {
+		entry  data.TxEntry
+		reason string
+	}{
+		{data.TxEntry{UniqueID: "trmissing", TxType: "add"}, ldapsearch.ErrNotFound.Error()},
+		{data.TxEntry{UniqueID: "trtwin", TxType: "update"}, ldapsearch.ErrMultipleEntries.Error()},
+	}
+	for _, tt := range tests {
+		if err := data.InsertTxEntry(&tt.entry); err != nil {
+			t.Fatal(err)
+		}
+		defer data.DeleteReview(tt.entry.UniqueID, tt.entry.TxType)
+	}
+
+	if err := processQueue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if data.LookupTxEntry(&tt.entry) {
+			t.Errorf("%+v is still queued\n", tt.entry)
+		}
+		r := data.LookupReview(tt.entry.UniqueID, tt.entry.TxType)
+		if r == nil || r.Status != data.ReviewDeadLetter || r.Reason != tt.reason {
+			t.Errorf("got review %+v for %+v, wanted a dead letter for %q\n", r, tt.entry, tt.reason)
+		}
+	}
+}
+
+func TestHoldIneligibleAgain(t *testing.T) {
+	var patches int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/auth/token":
+			fmt.Fprintf(w, `{"token": "t1", "expires": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case r.Method == "PATCH" && r.URL.Path == "/api/v1/computers-inventory-detail/41":
+			patches++
+			fmt.Fprint(w, `{"id": "41"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	saved, savedAuth := config.C, jamf.Auth
+	defer func() { config.C, jamf.Auth = saved, savedAuth }()
+	config.C.JssUrl = ts.URL
+	config.C.ExtensionAttributeID = 9
+	config.C.Eligibility = config.EligibilityConfig{
+		Rules: []config.EligibilityRule{{Attribute: "eduPersonAffiliation", Values: []string{"staff"}}},
+	}
+	jamf.Auth = jamf.NewSession(jamf.AuthBasic)
+	if err := data.ReplaceLicenseSources([]data.LicenseSource{{UniqueID: "trreject", Kind: jamf.KindComputer, DeviceID: 41}}); err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	err := data.InsertReview(&data.Review{UniqueID: "trreject", TxType: "add", Reason: "eduPersonAffiliation", Status: data.ReviewRejected, Created: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		data.DeleteReview("trreject", "add")
+		data.ReplaceLicenseSources(nil)
+		data.SetWriteBackDevices("trreject", nil)
+	}()
+	entry := data.TxEntry{UniqueID: "trreject", TxType: "add"}
+	person := &ldapsearch.Person{Uid: "trreject", FirstName: "Tess", Attributes: map[string][]string{"eduPersonAffiliation": {"student"}}}
+	tests := []struct {
+		name    string
+		action  string
+		status  string
+		kept    bool
+		patches int
+	}{
+		{"rejected again", "", data.ReviewRejected, true, 0},
+		{"now held for review", "review", data.ReviewPending, false, 1},
+	}
+	for _, tt := range tests {
+		config.C.Eligibility.Action = tt.action
+		patches = 0
+		if err := data.InsertTxEntry(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if !holdIneligible(context.Background(), entry, person) {
+			t.Fatalf("%s: trreject was eligible\n", tt.name)
+		}
+		if data.LookupTxEntry(&entry) {
+			t.Errorf("%s: trreject is still queued\n", tt.name)
+		}
+		r := data.LookupReview("trreject", "add")
+		if r == nil || r.Status != tt.status || r.Created.Equal(first) != tt.kept {
+			t.Errorf("%s: got review %+v, wanted %s created %v: %v\n", tt.name, r, tt.status, first, tt.kept)
+		}
+		if patches != tt.patches {
+			t.Errorf("%s: wrote back %d times, wanted %d\n", tt.name, patches, tt.patches)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Registry holds every Mudwork metric, apart from Prometheus's global
+// default registry so libraries can't add series to /metrics
+var Registry = prometheus.NewRegistry()
+
+// TokenRefreshFailures counts failed token requests to Adobe and the
+// JSS, which both need it
+var TokenRefreshFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mudwork_token_refresh_failures_total",
+		Help: "Total number of failed attempts to get or renew an access token",
+	},
+	[]string{"service"},
+)
+
+func init() {
+	Registry.MustRegister(prometheus.NewGoCollector())
+	Registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	Registry.MustRegister(TokenRefreshFailures)
+}
+
+// MustRegister registers collectors with Registry and panics if any of
+// them can't be
+func MustRegister(cs ...prometheus.Collector) {
+	Registry.MustRegister(cs...)
+}
+
+// Handler serves the metrics in Registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
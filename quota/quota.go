@@ -0,0 +1,372 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/directory"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"github.com/cosmouser/mudwork/logging"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/cosmouser/mudwork/umapi"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions for adds over the threshold
+const (
+	ActionHold   = "hold"
+	ActionReject = "reject"
+)
+
+// Priority rules for handing out the seats left
+const (
+	PriorityQueued    = "queued"
+	PriorityDevices   = "devices"
+	PriorityAttribute = "attribute"
+)
+
+// Reason is recorded against adds held or rejected for the quota
+const Reason = "license quota reached"
+
+var (
+	quotaSeats = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mudwork_license_quota_seats",
+		Help: "License quota of the AdobeGroup, 0 when unlimited",
+	})
+	quotaMembers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mudwork_license_quota_members",
+		Help: "Number of members of the AdobeGroup",
+	})
+	quotaUtilization = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mudwork_license_quota_utilization_ratio",
+		Help: "Fraction of the AdobeGroup's license quota in use",
+	})
+	quotaHeld = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mudwork_license_quota_held",
+		Help: "Number of adds waiting for a seat under the license quota",
+	})
+	quotaLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mudwork_license_quota_limited_total",
+			Help: "Total number of adds held or rejected because of the license quota",
+		},
+		[]string{"action"},
+	)
+)
+
+func init() {
+	metrics.MustRegister(quotaSeats)
+	metrics.MustRegister(quotaMembers)
+	metrics.MustRegister(quotaUtilization)
+	metrics.MustRegister(quotaHeld)
+	metrics.MustRegister(quotaLimited)
+}
+
+// getGroups and getPeople are replaced in tests
+var (
+	getGroups = umapi.GetGroups
+	getPeople = directory.GetPeople
+)
+
+// Enabled reports whether adds are checked against the quota
+func Enabled() bool {
+	return config.C.Quota.Threshold > 0
+}
+
+// ValidAction reports whether action is one the quota understands
+func ValidAction(action string) bool {
+	return action == "" || action == ActionHold || action == ActionReject
+}
+
+// ValidPriority reports whether priority is one the quota understands
+func ValidPriority(priority string) bool {
+	switch priority {
+	case "", PriorityQueued, PriorityDevices, PriorityAttribute:
+		return true
+	}
+	return false
+}
+
+// Usage is the AdobeGroup's license quota and how many seats are taken.
+// Quota is 0 when the group is unlimited.
+type Usage struct {
+	Quota   int
+	Members int
+}
+
+// Room returns how many more users fit under the threshold, or -1 when
+// the group is unlimited
+func (u Usage) Room() int {
+	if u.Quota <= 0 {
+		return -1
+	}
+	room := u.Quota*config.C.Quota.Threshold/100 - u.Members
+	if room < 0 {
+		return 0
+	}
+	return room
+}
+
+// Fetch looks up the AdobeGroup's quota and member count, updates the
+// quota metrics and warns when usage rises past a WarnAt level
+func Fetch(token *umapi.AccessResponse) (Usage, error) {
+	groups, err := getGroups(token)
+	if err != nil {
+		return Usage{}, err
+	}
+	for _, g := range groups {
+		if g.GroupName != config.C.AdobeGroup {
+			continue
+		}
+		u := Usage{Members: g.MemberCount}
+		// anything but a number, such as "UNLIMITED", has no quota
+		if n, err := strconv.Atoi(strings.TrimSpace(g.LicenseQuota)); err == nil {
+			u.Quota = n
+		}
+		observe(u)
+		return u, nil
+	}
+	return Usage{}, fmt.Errorf("group %s not found in Adobe org", config.C.AdobeGroup)
+}
+
+var (
+	warnedMu sync.Mutex
+	// warned is the highest WarnAt level usage was last seen past
+	warned int
+)
+
+// observe records u in the metrics and logs a warning the first time
+// usage is seen past each WarnAt level
+func observe(u Usage) {
+	quotaSeats.Set(float64(u.Quota))
+	quotaMembers.Set(float64(u.Members))
+	if u.Quota <= 0 {
+		quotaUtilization.Set(0)
+		return
+	}
+	ratio := float64(u.Members) / float64(u.Quota)
+	quotaUtilization.Set(ratio)
+	level := 0
+	for _, j := range config.C.Quota.WarnAt {
+		if ratio*100 >= float64(j) && j > level {
+			level = j
+		}
+	}
+	warnedMu.Lock()
+	defer warnedMu.Unlock()
+	if level > warned {
+		log.WithFields(log.Fields{
+			"group":         config.C.AdobeGroup,
+			"quota":         u.Quota,
+			"members":       u.Members,
+			"warning_level": level,
+		}).Warn("License quota usage past warning level")
+	}
+	warned = level
+}
+
+// Limit splits a batch into the entries to send and the adds that don't
+// fit under the threshold. The seats left go to adds in Priority order
+// and entries keeps its order otherwise.
+func Limit(u Usage, entries []data.TxEntry, people map[string]ldapsearch.Result) (send, over []data.TxEntry) {
+	room := u.Room()
+	adds := []candidate{}
+	for _, j := range entries {
+		if j.TxType == "add" {
+			adds = append(adds, candidate{entry: j, person: people[j.UniqueID].Person})
+		}
+	}
+	if room < 0 || len(adds) <= room {
+		return entries, nil
+	}
+	rank(adds)
+	left := make(map[data.TxEntry]bool)
+	for _, j := range adds[room:] {
+		left[j.entry] = true
+		over = append(over, j.entry)
+	}
+	for _, j := range entries {
+		if !left[j] {
+			send = append(send, j)
+		}
+	}
+	return send, over
+}
+
+// Hold takes an add that doesn't fit off the txlog and records it under
+// the review table with the configured action. It returns the review
+// status, ReviewQuota for a hold or ReviewRejected.
+func Hold(j data.TxEntry) string {
+	status := data.ReviewQuota
+	if config.C.Quota.Action == ActionReject {
+		status = data.ReviewRejected
+	}
+	err := data.InsertReview(&data.Review{
+		UniqueID: j.UniqueID,
+		TxType:   j.TxType,
+		Reason:   Reason,
+		Status:   status,
+		Created:  time.Now(),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"uid":   j.UniqueID,
+			"table": "review",
+		}).Warn(err)
+	}
+	err = data.DeleteTxEntry(&j)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"uid":    j.UniqueID,
+			"txtype": j.TxType,
+		}).Warn("unable to remove TxEntry")
+	}
+	action := ActionHold
+	if status == data.ReviewRejected {
+		action = ActionReject
+	}
+	quotaLimited.With(prometheus.Labels{"action": action}).Inc()
+	log.WithFields(log.Fields{
+		"uid":    j.UniqueID,
+		"txtype": j.TxType,
+		"status": status,
+	}).Warn("License quota reached, removing from transaction log")
+	return status
+}
+
+// Release queues held adds, in Priority order, for as many seats as the
+// threshold allows, and drops their holds. Holds for users no longer
+// licensed by a device in the JSS are dropped too. It returns the number
+// of adds queued.
+func Release(ctx context.Context, token *umapi.AccessResponse) int {
+	holds := []candidate{}
+	for _, r := range data.GetReviews(data.ReviewQuota) {
+		if len(data.GetLicenseSources(r.UniqueID)) == 0 {
+			if err := data.DeleteReview(r.UniqueID, r.TxType); err != nil {
+				logging.From(ctx).WithFields(log.Fields{
+					"uid":   r.UniqueID,
+					"table": "review",
+				}).Warn(err)
+			}
+			continue
+		}
+		holds = append(holds, candidate{entry: data.TxEntry{UniqueID: r.UniqueID, TxType: r.TxType}})
+	}
+	quotaHeld.Set(float64(len(holds)))
+	if len(holds) == 0 {
+		return 0
+	}
+	u, err := Fetch(token)
+	if err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"error": err,
+		}).Warn("Unable to check license quota, leaving adds held")
+		return 0
+	}
+	room := u.Room()
+	if room == 0 {
+		return 0
+	}
+	if config.C.Quota.Priority == PriorityAttribute {
+		uids := make([]string, len(holds))
+		for i, j := range holds {
+			uids[i] = j.entry.UniqueID
+		}
+		people, err := getPeople(ctx, uids)
+		if err != nil {
+			logging.From(ctx).WithFields(log.Fields{
+				"error": err,
+			}).Warn("Unable to rank held adds, leaving them held")
+			return 0
+		}
+		for i := range holds {
+			holds[i].person = people[holds[i].entry.UniqueID].Person
+		}
+	}
+	rank(holds)
+	if room > 0 && room < len(holds) {
+		holds = holds[:room]
+	}
+	entries := make([]data.TxEntry, len(holds))
+	for i, j := range holds {
+		entries[i] = j.entry
+	}
+	if err := data.InsertTxEntries(entries); err != nil {
+		logging.From(ctx).WithFields(log.Fields{
+			"table": "txlog",
+			"error": err,
+		}).Warn("Could not queue held adds")
+		return 0
+	}
+	// the hold is over once the add is queued. Eligibility is checked
+	// again when it is sent, as no administrator approved it.
+	for _, j := range entries {
+		if err := data.DeleteReview(j.UniqueID, j.TxType); err != nil {
+			logging.From(ctx).WithFields(log.Fields{
+				"uid":   j.UniqueID,
+				"table": "review",
+			}).Warn(err)
+		}
+	}
+	quotaHeld.Sub(float64(len(entries)))
+	logging.From(ctx).WithFields(log.Fields{
+		"released": len(entries),
+		"room":     room,
+	}).Info("Seats free under license quota, held adds queued")
+	return len(entries)
+}
+
+// candidate is an add waiting on a seat
+type candidate struct {
+	entry  data.TxEntry
+	person *ldapsearch.Person
+}
+
+// rank orders candidates by the Priority rule, keeping their order
+// among equals
+func rank(cs []candidate) {
+	switch config.C.Quota.Priority {
+	case PriorityDevices:
+		devices := make(map[string]int)
+		for _, j := range data.GetAllLicenseSources() {
+			devices[j.UniqueID]++
+		}
+		sort.SliceStable(cs, func(a, b int) bool {
+			return devices[cs[a].entry.UniqueID] > devices[cs[b].entry.UniqueID]
+		})
+	case PriorityAttribute:
+		sort.SliceStable(cs, func(a, b int) bool {
+			return attributeRank(cs[a].person) < attributeRank(cs[b].person)
+		})
+	}
+}
+
+// attributeRank is the position in PriorityValues of the first value
+// the person has for PriorityAttribute. People with none come last.
+func attributeRank(person *ldapsearch.Person) int {
+	best := len(config.C.Quota.PriorityValues)
+	if person == nil {
+		return best
+	}
+	for name, values := range person.Attributes {
+		// attribute names are case insensitive in LDAP
+		if !strings.EqualFold(name, config.C.Quota.PriorityAttribute) {
+			continue
+		}
+		for _, v := range values {
+			for i, want := range config.C.Quota.PriorityValues {
+				if i < best && strings.EqualFold(v, want) {
+					best = i
+				}
+			}
+		}
+	}
+	return best
+}
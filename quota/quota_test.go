@@ -0,0 +1,133 @@
+package quota
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	"github.com/cosmouser/mudwork/umapi"
+	"reflect"
+	"testing"
+)
+
+func TestLimit(t *testing.T) {
+	saved := config.C
+	defer func() { config.C = saved }()
+	config.C.Quota = config.QuotaConfig{
+		Threshold:         90,
+		Priority:          PriorityAttribute,
+		PriorityAttribute: "eduPersonAffiliation",
+		PriorityValues:    []string{"faculty", "staff"},
+	}
+	entries := []data.TxEntry{
+		{UniqueID: "student1", TxType: "add"},
+		{UniqueID: "gone", TxType: "remove"},
+		{UniqueID: "staff1", TxType: "add"},
+		{UniqueID: "faculty1", TxType: "add"},
+	}
+	affiliation := func(v string) ldapsearch.Result {
+		return ldapsearch.Result{Person: &ldapsearch.Person{Attributes: map[string][]string{"eduPersonAffiliation": {v}}}}
+	}
+	people := map[string]ldapsearch.Result{
+		"student1": affiliation("student"),
+		"staff1":   affiliation("Staff"),
+		"faculty1": affiliation("faculty"),
+	}
+	// 90% of 10 seats leaves room for one more
+	send, over := Limit(Usage{Quota: 10, Members: 8}, entries, people)
+	wantSend := []data.TxEntry{entries[1], entries[3]}
+	wantOver := []data.TxEntry{entries[2], entries[0]}
+	if !reflect.DeepEqual(send, wantSend) || !reflect.DeepEqual(over, wantOver) {
+		t.Errorf("got %v %v, wanted %v %v\n", send, over, wantSend, wantOver)
+	}
+	// an unlimited group takes everything
+	send, over = Limit(Usage{Members: 500}, entries, people)
+	if len(send) != len(entries) || len(over) != 0 {
+		t.Errorf("got %v %v, wanted every entry sent\n", send, over)
+	}
+	// over the threshold already, no adds fit
+	send, over = Limit(Usage{Quota: 10, Members: 10}, entries, people)
+	if len(send) != 1 || len(over) != 3 {
+		t.Errorf("got %v %v, wanted only the remove sent\n", send, over)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	saved, savedGroups := config.C, getGroups
+	defer func() { config.C, getGroups = saved, savedGroups }()
+	config.C.AdobeGroup = "Acrobat"
+	config.C.Quota = config.QuotaConfig{Threshold: 100, WarnAt: []int{80, 95}}
+	getGroups = func(*umapi.AccessResponse) ([]umapi.Group, error) {
+		return []umapi.Group{
+			{GroupName: "Photoshop", MemberCount: 3, LicenseQuota: "UNLIMITED"},
+			{GroupName: "Acrobat", MemberCount: 85, LicenseQuota: "100"},
+		}, nil
+	}
+	u, err := Fetch(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Quota != 100 || u.Members != 85 || u.Room() != 15 {
+		t.Errorf("got %+v room %d\n", u, u.Room())
+	}
+	if warned != 80 {
+		t.Errorf("warned at %d, wanted 80\n", warned)
+	}
+	config.C.AdobeGroup = "Photoshop"
+	u, err = Fetch(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Room() != -1 {
+		t.Errorf("got room %d for an unlimited group\n", u.Room())
+	}
+	config.C.AdobeGroup = "Illustrator"
+	if _, err = Fetch(nil); err == nil {
+		t.Error("wanted an error for a group that isn't in the org")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	saved, savedGroups := config.C, getGroups
+	defer func() { config.C, getGroups = saved, savedGroups }()
+	config.C.AdobeGroup = "Acrobat"
+	config.C.Quota = config.QuotaConfig{Threshold: 100, Priority: PriorityDevices}
+	getGroups = func(*umapi.AccessResponse) ([]umapi.Group, error) {
+		return []umapi.Group{{GroupName: "Acrobat", MemberCount: 9, LicenseQuota: "10"}}, nil
+	}
+	err := data.ReplaceLicenseSources([]data.LicenseSource{
+		{UniqueID: "heldone", Kind: "computer", DeviceID: 1},
+		{UniqueID: "heldtwo", Kind: "computer", DeviceID: 2},
+		{UniqueID: "heldtwo", Kind: "mobile", DeviceID: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.ReplaceLicenseSources(nil)
+	for _, j := range []string{"heldone", "heldtwo", "heldgone"} {
+		if status := Hold(data.TxEntry{UniqueID: j, TxType: "add"}); status != data.ReviewQuota {
+			t.Errorf("got status %s, wanted %s\n", status, data.ReviewQuota)
+		}
+		defer data.DeleteReview(j, "add")
+		defer data.DeleteTxEntry(&data.TxEntry{UniqueID: j, TxType: "add"})
+	}
+
+	// one seat goes to heldtwo with the most devices, and heldgone
+	// has left the JSS
+	if released := Release(context.Background(), nil); released != 1 {
+		t.Errorf("released %d, wanted 1\n", released)
+	}
+	if !data.LookupTxEntry(&data.TxEntry{UniqueID: "heldtwo", TxType: "add"}) {
+		t.Error("heldtwo was not queued")
+	}
+	// a released hold isn't an approval, so eligibility still applies
+	if r := data.LookupReview("heldtwo", "add"); r != nil {
+		t.Errorf("got review %+v for heldtwo, wanted it dropped\n", r)
+	}
+	if r := data.LookupReview("heldone", "add"); r == nil || r.Status != data.ReviewQuota {
+		t.Errorf("got review %+v for heldone, wanted still held\n", r)
+	}
+	if r := data.LookupReview("heldgone", "add"); r != nil {
+		t.Errorf("got review %+v for heldgone, wanted it dropped\n", r)
+	}
+}
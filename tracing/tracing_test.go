@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"testing"
+)
+
+func TestValidExporter(t *testing.T) {
+	for _, j := range []string{"", ExporterOTLP, ExporterStdout} {
+		if !ValidExporter(j) {
+			t.Errorf("%q was not valid\n", j)
+		}
+	}
+	if ValidExporter("jaeger") {
+		t.Error("jaeger was valid")
+	}
+}
+
+func TestInit(t *testing.T) {
+	saved := config.C
+	defer func() { config.C = saved }()
+	config.C.Tracing = config.TracingConfig{}
+	shutdown, err := Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+	config.C.Tracing.Exporter = "jaeger"
+	if _, err = Init(); err == nil {
+		t.Error("wanted an error for an unknown exporter")
+	}
+}
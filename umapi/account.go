@@ -0,0 +1,79 @@
+package umapi
+
+import (
+	"context"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/directory"
+	"github.com/cosmouser/mudwork/ldapsearch"
+	log "github.com/sirupsen/logrus"
+)
+
+// Account types accepted by AccountType and AccountTypes in the config
+const (
+	FederatedID  = "federatedID"
+	EnterpriseID = "enterpriseID"
+	AdobeID      = "adobeID"
+)
+
+// AccountTypeFor returns the type of account a user is provisioned
+// with. A per-user entry in AccountTypes wins over AccountType, and
+// Federated ID is used when neither is set.
+func AccountTypeFor(uid string) string {
+	if accountType, ok := config.C.AccountTypes[uid]; ok {
+		return accountType
+	}
+	if config.C.AccountType != "" {
+		return config.C.AccountType
+	}
+	return FederatedID
+}
+
+// lookupIdentity is replaced in tests
+var lookupIdentity = data.LookupIdentity
+
+// ValidAccountType reports whether accountType is one mudwork can create
+func ValidAccountType(accountType string) bool {
+	switch accountType {
+	case FederatedID, EnterpriseID, AdobeID:
+		return true
+	}
+	return false
+}
+
+// GenCreateAction returns the action that creates an account of the
+// given type for person, leaving existing accounts alone. The second
+// return value is true for Adobe IDs, whose items must set useAdobeID.
+func GenCreateAction(person *ldapsearch.Person, accountType string) (Action, bool) {
+	const option = "ignoreIfAlreadyExists"
+	switch accountType {
+	case EnterpriseID:
+		return Action{CreateEntID: &ActionCreateEntID{person.Country, person.Email, person.FirstName, person.LastName, option}}, false
+	case AdobeID:
+		return Action{AddAdobeID: &ActionAddAdobeID{person.Country, person.Email, person.FirstName, person.LastName, option}}, true
+	default:
+		return Action{CreateFedID: &ActionCreateFedID{person.Country, person.Email, person.FirstName, person.LastName, option}}, false
+	}
+}
+
+// identify returns an Item addressing an existing user. Accounts are
+// addressed by the email they were created with, which need not be
+// uid@Domain. For users licensed before that was recorded, Federated
+// and Enterprise IDs are addressed by username within the domain and
+// Adobe IDs by their email, which is looked up in the directory.
+func identify(user string) Item {
+	if id := lookupIdentity(user); id != nil {
+		return Item{User: id.User, UseAdobeID: id.AdobeID}
+	}
+	if AccountTypeFor(user) != AdobeID {
+		return Item{User: user, Domain: config.C.Enterprise["Domain"]}
+	}
+	person, err := directory.GetPerson(context.Background(), user)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"user": user,
+		}).Warn("Directory search failed")
+		person = &ldapsearch.Person{Email: ldapsearch.DefaultEmail(user)}
+	}
+	return Item{User: person.Email, UseAdobeID: true}
+}
@@ -3,7 +3,6 @@ package umapi
 import (
 	"github.com/cosmouser/mudwork/config"
 	"github.com/cosmouser/mudwork/ldapsearch"
-	log "github.com/sirupsen/logrus"
 )
 
 type Items []Item
@@ -14,10 +13,14 @@ type Item struct {
 	User       string   `json:"user"`
 }
 type Action struct {
-	AddAdobeID  *ActionAddAdobeID  `json:"addAdobeID,omitempty"`
-	CreateFedID *ActionCreateFedID `json:"createFederatedID,omitempty"`
-	Add         *ActionAdd         `json:"add,omitempty"`
-	Remove      *ActionRemove      `json:"remove,omitempty"`
+	AddAdobeID       *ActionAddAdobeID       `json:"addAdobeID,omitempty"`
+	CreateFedID      *ActionCreateFedID      `json:"createFederatedID,omitempty"`
+	CreateEntID      *ActionCreateEntID      `json:"createEnterpriseID,omitempty"`
+	Update           *ActionUpdate           `json:"update,omitempty"`
+	Add              *ActionAdd              `json:"add,omitempty"`
+	Remove           *ActionRemove           `json:"remove,omitempty"`
+	RemoveFromOrg    *ActionRemoveFromOrg    `json:"removeFromOrg,omitempty"`
+	RemoveFromDomain *ActionRemoveFromDomain `json:"removeFromDomain,omitempty"`
 }
 type ActionAdd struct {
 	Group []string `json:"group"`
@@ -39,6 +42,24 @@ type ActionCreateFedID struct {
 	LastName  string `json:"lastname"`
 	Option    string `json:"option,omitempty"`
 }
+type ActionCreateEntID struct {
+	Country   string `json:"country"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Option    string `json:"option,omitempty"`
+}
+type ActionUpdate struct {
+	Country   string `json:"country,omitempty"`
+	Email     string `json:"email,omitempty"`
+	FirstName string `json:"firstname,omitempty"`
+	LastName  string `json:"lastname,omitempty"`
+	Username  string `json:"username,omitempty"`
+}
+type ActionRemoveFromOrg struct {
+	DeleteAccount bool `json:"deleteAccount"`
+}
+type ActionRemoveFromDomain struct{}
 type GroupResponse struct {
 	LastPage bool    `json:"lastPage"`
 	Result   string  `json:"result"`
@@ -83,20 +104,15 @@ type ActionResponseWarning struct {
 // marshalled into json and sent to the Adobe endpoint as a request body
 
 // GenAddItem creates an Item for adding a user to a group. It also
-// creates a federated ID for the user if one does not already exist
-func GenAddItem(user, group string) Item {
+// creates an account of the user's AccountType if one does not already
+// exist
+func GenAddItem(person *ldapsearch.Person, group string) Item {
 	groupSlice := []string{group}
 	addAction := &ActionAdd{groupSlice}
-	person, err := ldapsearch.GetPerson(user)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"user": user,
-		}).Warn("Ldap search failed")
-	}
-	createAction := &ActionCreateFedID{"US", person.Email, person.FirstName, person.LastName, "ignoreIfAlreadyExists"}
-	actions := Action{Add: addAction, CreateFedID: createAction}
+	actions, useAdobeID := GenCreateAction(person, AccountTypeFor(person.Uid))
+	actions.Add = addAction
 	uac := []Action{actions}
-	item := Item{User: person.Email, Do: uac}
+	item := Item{User: person.Email, Do: uac, UseAdobeID: useAdobeID}
 	return item
 }
 
@@ -105,8 +121,39 @@ func GenRemoveItem(user, group string) Item {
 	groupSlice := []string{group}
 	removeAction := &ActionRemove{groupSlice}
 	action := Action{Remove: removeAction}
-	uac := []Action{action}
-	item := Item{User: user, Do: uac, Domain: config.C.Enterprise["Domain"]}
+	item := identify(user)
+	item.Do = []Action{action}
+	return item
+}
+
+// GenUpdateItem creates an Item that brings the user's name in line
+// with the directory. Email and country are left alone since Adobe
+// treats them as part of the account's identity.
+func GenUpdateItem(person *ldapsearch.Person) Item {
+	updateAction := &ActionUpdate{FirstName: person.FirstName, LastName: person.LastName}
+	action := Action{Update: updateAction}
+	item := identify(person.Uid)
+	item.Do = []Action{action}
+	return item
+}
+
+// GenRemoveFromOrgItem creates an Item that removes the user from the
+// org entirely. deleteAccount also deletes a Federated or Enterprise ID
+// owned by the org.
+func GenRemoveFromOrgItem(user string, deleteAccount bool) Item {
+	removeAction := &ActionRemoveFromOrg{deleteAccount}
+	action := Action{RemoveFromOrg: removeAction}
+	item := identify(user)
+	item.Do = []Action{action}
+	return item
+}
+
+// GenRemoveFromDomainItem creates an Item that deletes the user's
+// account from the org's claimed domain
+func GenRemoveFromDomainItem(user string) Item {
+	action := Action{RemoveFromDomain: &ActionRemoveFromDomain{}}
+	item := identify(user)
+	item.Do = []Action{action}
 	return item
 }
 
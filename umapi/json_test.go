@@ -2,6 +2,9 @@ package umapi
 
 import (
 	"encoding/json"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/data"
+	"github.com/cosmouser/mudwork/ldapsearch"
 	"testing"
 )
 
@@ -132,3 +135,140 @@ func TestActionResponse(t *testing.T) {
 	}
 
 }
+
+func TestUsersResponse(t *testing.T) {
+	usersResponse := `
+{
+  "lastPage": true,
+  "result": "success",
+  "users": [
+    {
+      "id": "4B0A1E2F5BE0B3A30A49420A@AdobeID",
+      "email": "jdoe@uni.edu",
+      "status": "active",
+      "groups": ["Acrobat DC", "Default Creative Cloud All Apps configuration"],
+      "username": "jdoe",
+      "domain": "uni.edu",
+      "firstname": "Jane",
+      "lastname": "Doe",
+      "country": "US",
+      "type": "federatedID"
+    },
+    {
+      "id": "5C1B2F3A6CF1C4B41B5A531B@AdobeID",
+      "email": "visitor@example.com",
+      "status": "active",
+      "username": "visitor@example.com",
+      "domain": "example.com",
+      "country": "GB",
+      "type": "adobeID"
+    }
+  ]
+}`
+	ur := &UsersResponse{}
+	err := json.Unmarshal([]byte(usersResponse), ur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ur.LastPage || len(ur.Users) != 2 {
+		t.Fatalf("got lastPage %v with %d users, wanted true with 2\n", ur.LastPage, len(ur.Users))
+	}
+	if !ur.Users[0].InGroup("Acrobat DC") {
+		t.Error("first user is not in Acrobat DC")
+	}
+	if ur.Users[1].InGroup("Acrobat DC") {
+		t.Error("second user is in Acrobat DC")
+	}
+	if got, want := ur.Users[1].Type, "adobeID"; got != want {
+		t.Errorf("got type %s, wanted %s\n", got, want)
+	}
+}
+
+func TestGenCreateAction(t *testing.T) {
+	person := &ldapsearch.Person{FirstName: "Jane", LastName: "Doe", Email: "jdoe@uni.edu", Country: "CA", Uid: "jdoe"}
+	tests := []struct {
+		accountType string
+		want        string
+		useAdobeID  bool
+	}{
+		{FederatedID, `{"createFederatedID":{"country":"CA","email":"jdoe@uni.edu","firstname":"Jane","lastname":"Doe","option":"ignoreIfAlreadyExists"}}`, false},
+		{EnterpriseID, `{"createEnterpriseID":{"country":"CA","email":"jdoe@uni.edu","firstname":"Jane","lastname":"Doe","option":"ignoreIfAlreadyExists"}}`, false},
+		{AdobeID, `{"addAdobeID":{"country":"CA","email":"jdoe@uni.edu","firstname":"Jane","lastname":"Doe","option":"ignoreIfAlreadyExists"}}`, true},
+	}
+	for _, tt := range tests {
+		action, useAdobeID := GenCreateAction(person, tt.accountType)
+		got, err := json.Marshal(action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want || useAdobeID != tt.useAdobeID {
+			t.Errorf("%s: got %s, %v, wanted %s, %v\n", tt.accountType, got, useAdobeID, tt.want, tt.useAdobeID)
+		}
+	}
+}
+
+func TestGenLifecycleItems(t *testing.T) {
+	saved, savedLookup := config.C, lookupIdentity
+	defer func() { config.C, lookupIdentity = saved, savedLookup }()
+	config.C.AccountType = EnterpriseID
+	config.C.AccountTypes = nil
+	config.C.Enterprise = map[string]string{"Domain": "uni.edu"}
+	lookupIdentity = func(string) *data.Identity { return nil }
+	person := &ldapsearch.Person{Uid: "jdoe", FirstName: "Jane", LastName: "Doe-Roe", Email: "jdoe@uni.edu", Country: "CA"}
+	tests := []struct {
+		name string
+		item Item
+		want string
+	}{
+		{"update", GenUpdateItem(person), `{"do":[{"update":{"firstname":"Jane","lastname":"Doe-Roe"}}],"domain":"uni.edu","user":"jdoe"}`},
+		{"removeFromOrg", GenRemoveFromOrgItem("jdoe", false), `{"do":[{"removeFromOrg":{"deleteAccount":false}}],"domain":"uni.edu","user":"jdoe"}`},
+		{"removeFromOrg deleteAccount", GenRemoveFromOrgItem("jdoe", true), `{"do":[{"removeFromOrg":{"deleteAccount":true}}],"domain":"uni.edu","user":"jdoe"}`},
+		{"removeFromDomain", GenRemoveFromDomainItem("jdoe"), `{"do":[{"removeFromDomain":{}}],"domain":"uni.edu","user":"jdoe"}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, wanted %s\n", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	saved, savedLookup := config.C, lookupIdentity
+	defer func() { config.C, lookupIdentity = saved, savedLookup }()
+	config.C.AccountType = FederatedID
+	config.C.AccountTypes = nil
+	config.C.Enterprise = map[string]string{"Domain": "uni.edu"}
+	// jdoe was created with an email that isn't uid@Domain, as an Adobe ID
+	// before AccountType was changed; mroe was licensed before identities
+	// were recorded
+	lookupIdentity = func(uid string) *data.Identity {
+		if uid == "jdoe" {
+			return &data.Identity{UniqueID: uid, User: "jane.doe@alumni.uni.edu", AdobeID: true}
+		}
+		return nil
+	}
+	tests := []struct {
+		name string
+		item Item
+		want string
+	}{
+		{"remove", GenRemoveItem("jdoe", "Default"), `{"do":[{"remove":{"group":["Default"]}}],"useAdobeID":true,"user":"jane.doe@alumni.uni.edu"}`},
+		{"update", GenUpdateItem(&ldapsearch.Person{Uid: "jdoe", FirstName: "Jane", LastName: "Doe", Email: "jdoe@uni.edu"}), `{"do":[{"update":{"firstname":"Jane","lastname":"Doe"}}],"useAdobeID":true,"user":"jane.doe@alumni.uni.edu"}`},
+		{"removeFromOrg", GenRemoveFromOrgItem("jdoe", false), `{"do":[{"removeFromOrg":{"deleteAccount":false}}],"useAdobeID":true,"user":"jane.doe@alumni.uni.edu"}`},
+		{"removeFromDomain", GenRemoveFromDomainItem("jdoe"), `{"do":[{"removeFromDomain":{}}],"useAdobeID":true,"user":"jane.doe@alumni.uni.edu"}`},
+		{"unrecorded", GenRemoveFromDomainItem("mroe"), `{"do":[{"removeFromDomain":{}}],"domain":"uni.edu","user":"mroe"}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, wanted %s\n", tt.name, got, tt.want)
+		}
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/logging"
 	"github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 )
@@ -27,11 +28,15 @@ type AccessResponse struct {
 func GenerateJwt() string {
 	signBytes, err := ioutil.ReadFile(config.C.Enterprise["PrivKeyPath"])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
+		log.WithFields(log.Fields{
+			"step": "read private key",
+		}).Error(err)
 	}
 	mySigningKey, err := jwt.ParseRSAPrivateKeyFromPEM(signBytes)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
+		log.WithFields(log.Fields{
+			"step": "parse private key",
+		}).Error(err)
 	}
 
 	aud := fmt.Sprintf("https://%s/c/%s", config.C.Server["ImsHost"], config.C.Enterprise["APIKey"])
@@ -55,8 +60,12 @@ func GenerateJwt() string {
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
 	ss, err := token.SignedString(mySigningKey)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
+		log.WithFields(log.Fields{
+			"step": "sign jwt",
+		}).Error(err)
 	}
+	// the assertion can be exchanged for an access token until it expires
+	logging.SetSecret("adobe.jwt", ss)
 	return ss
 }
 
@@ -81,26 +90,22 @@ func RequestAccess(body string) (*AccessResponse, error) {
 	resourceURI := fmt.Sprintf("https://%s%s", config.C.Server["ImsHost"], config.C.Server["ImsEndpointJwt"])
 	req, err := http.NewRequest("POST", resourceURI, bodyReader)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Cache-Control", "no-cache")
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 	output, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return nil, err
 	}
 	var accResp AccessResponse
 	err = json.Unmarshal(output, &accResp)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return nil, err
 	}
 	return &accResp, nil
@@ -0,0 +1,157 @@
+package umapi
+
+import (
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that paces requests to the User Management
+// API. It starts from the configured budget and adapts to the
+// X-RateLimit headers Adobe returns with each response, so mudwork slows
+// down before it is throttled rather than after.
+type Limiter struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64 // tokens added per second
+	tokens   float64
+	last     time.Time
+	// resumeAt is set when Adobe reports an exhausted budget
+	resumeAt time.Time
+	now      func() time.Time
+}
+
+// Throttle paces every request sent through send
+var Throttle *Limiter
+
+var (
+	rateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mudwork_umapi_ratelimit_remaining",
+		Help: "Requests remaining in the current User Management API rate limit window",
+	})
+)
+
+func init() {
+	requests, window := 25, 60
+	if config.C.RateLimit.Requests > 0 {
+		requests = config.C.RateLimit.Requests
+	}
+	if config.C.RateLimit.Window > 0 {
+		window = config.C.RateLimit.Window
+	}
+	Throttle = NewLimiter(requests, time.Duration(window)*time.Second)
+	metrics.MustRegister(rateLimitRemaining)
+	rateLimitRemaining.Set(float64(requests))
+}
+
+// NewLimiter returns a full bucket allowing requests per window
+func NewLimiter(requests int, window time.Duration) *Limiter {
+	l := &Limiter{
+		capacity: float64(requests),
+		rate:     float64(requests) / window.Seconds(),
+		tokens:   float64(requests),
+		now:      time.Now,
+	}
+	l.last = l.now()
+	return l
+}
+
+// refill adds the tokens earned since the last call. Callers hold l.mu.
+func (l *Limiter) refill(now time.Time) {
+	if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens += elapsed * l.rate
+		if l.tokens > l.capacity {
+			l.tokens = l.capacity
+		}
+	}
+	l.last = now
+}
+
+// reserve takes a token and returns how long the caller must wait
+// before using it.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.refill(now)
+	var wait time.Duration
+	if now.Before(l.resumeAt) {
+		wait = l.resumeAt.Sub(now)
+	}
+	l.tokens--
+	if l.tokens < 0 {
+		if deficit := time.Duration(-l.tokens / l.rate * float64(time.Second)); deficit > wait {
+			wait = deficit
+		}
+	}
+	return wait
+}
+
+// Wait blocks until the bucket allows another request
+func (l *Limiter) Wait() {
+	if wait := l.reserve(); wait > 0 {
+		sleep(wait)
+	}
+}
+
+// Update adjusts the bucket from a response's rate limit headers.
+// X-RateLimit-Limit resizes the bucket, X-RateLimit-Remaining caps the
+// tokens on hand and X-RateLimit-Reset, in seconds or as a unix time,
+// says when an exhausted budget comes back. A 429 always empties the
+// bucket.
+func (l *Limiter) Update(resp *http.Response) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.refill(now)
+	h := resp.Header
+	if limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit")); err == nil && limit > 0 {
+		window := l.capacity / l.rate
+		l.capacity = float64(limit)
+		l.rate = l.capacity / window
+	}
+	if remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining")); err == nil && remaining >= 0 {
+		if float64(remaining) < l.tokens {
+			l.tokens = float64(remaining)
+		}
+		if remaining == 0 {
+			if reset, ok := parseReset(h.Get("X-RateLimit-Reset"), now); ok {
+				l.resumeAt = reset
+			}
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests && l.tokens > 0 {
+		l.tokens = 0
+	}
+	remaining := l.tokens
+	if remaining < 0 {
+		remaining = 0
+	}
+	rateLimitRemaining.Set(remaining)
+}
+
+// Remaining returns the number of requests currently available
+func (l *Limiter) Remaining() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(l.now())
+	return l.tokens
+}
+
+// parseReset reads X-RateLimit-Reset, which is either a number of
+// seconds from now or a unix timestamp.
+func parseReset(value string, now time.Time) (time.Time, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	// anything this large is an absolute time rather than a delay
+	if n > 1000000000 {
+		return time.Unix(n, 0), true
+	}
+	return now.Add(time.Duration(n) * time.Second), true
+}
@@ -0,0 +1,50 @@
+package umapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	clock := time.Date(2018, time.November, 5, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(2, time.Second*10)
+	l.now = func() time.Time { return clock }
+	l.last = clock
+
+	if wait := l.reserve(); wait != 0 {
+		t.Errorf("first request waited %v, wanted 0\n", wait)
+	}
+	if wait := l.reserve(); wait != 0 {
+		t.Errorf("second request waited %v, wanted 0\n", wait)
+	}
+	if wait := l.reserve(); wait != time.Second*5 {
+		t.Errorf("third request waited %v, wanted 5s\n", wait)
+	}
+
+	clock = clock.Add(time.Second * 20)
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+	resp.Header.Set("X-RateLimit-Limit", "4")
+	resp.Header.Set("X-RateLimit-Remaining", "0")
+	resp.Header.Set("X-RateLimit-Reset", "30")
+	l.Update(resp)
+	if got := l.Remaining(); got != 0 {
+		t.Errorf("Remaining returned %v after header reported 0\n", got)
+	}
+	if wait := l.reserve(); wait != time.Second*30 {
+		t.Errorf("request waited %v, wanted the 30s reset\n", wait)
+	}
+}
+
+func TestParseReset(t *testing.T) {
+	now := time.Unix(1541419200, 0)
+	if got, ok := parseReset("15", now); !ok || !got.Equal(now.Add(time.Second*15)) {
+		t.Errorf("parseReset(15) = %v, %v\n", got, ok)
+	}
+	if got, ok := parseReset("1541419260", now); !ok || !got.Equal(time.Unix(1541419260, 0)) {
+		t.Errorf("parseReset(unix) = %v, %v\n", got, ok)
+	}
+	if _, ok := parseReset("", now); ok {
+		t.Error("parseReset accepted an empty header")
+	}
+}
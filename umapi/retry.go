@@ -0,0 +1,178 @@
+package umapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/logging"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how requests to Adobe's endpoints are retried
+// when they are throttled, fail with a server error or cannot be sent.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// RetryError is returned by Do once a request has failed MaxAttempts times.
+// StatusCode is 0 when the last attempt failed before a response arrived.
+type RetryError struct {
+	Request    string
+	Attempts   int
+	StatusCode int
+	Err        error
+}
+
+func (e *RetryError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s failed after %d attempts: %v", e.Request, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("%s failed after %d attempts: last response code %d", e.Request, e.Attempts, e.StatusCode)
+}
+
+// Retry is the policy shared by every request mudwork makes to Adobe
+var Retry RetryPolicy
+
+// sleep is swapped out by tests
+var sleep = time.Sleep
+
+func init() {
+	Retry = newRetryPolicy(config.C.Retry)
+}
+
+// newRetryPolicy fills in defaults for any values missing from the config
+func newRetryPolicy(c config.RetryConfig) RetryPolicy {
+	p := RetryPolicy{
+		MaxAttempts: 8,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute * 5,
+	}
+	if c.MaxAttempts > 0 {
+		p.MaxAttempts = c.MaxAttempts
+	}
+	if c.BaseDelay > 0 {
+		p.BaseDelay = time.Duration(c.BaseDelay) * time.Second
+	}
+	if c.MaxDelay > 0 {
+		p.MaxDelay = time.Duration(c.MaxDelay) * time.Second
+	}
+	return p
+}
+
+// ParseRetryAfter reads a Retry-After header value, which may be either
+// a number of seconds or an HTTP date. The second return value is false
+// when the header is missing or could not be understood.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// Backoff returns how long to wait before retrying after the given
+// attempt (starting at 1). The exponential delay is jittered so that
+// concurrent callers spread out, a server supplied retryAfter is always
+// honored as a minimum, and the result never exceeds MaxDelay.
+func (p RetryPolicy) Backoff(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// equal jitter: keep half of the delay, randomize the other half
+	half := delay / 2
+	if half > 0 {
+		delay = half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+	if retryAfter > 0 {
+		// add up to an additional second for good measure
+		if wait := retryAfter + time.Duration(rand.Int63n(int64(time.Second))); wait > delay {
+			delay = wait
+		}
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// retryable reports whether a response code warrants another attempt
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Do calls send until it returns a response that should not be retried
+// or MaxAttempts is reached. Network errors, 429 and 5xx responses are
+// retried; every other response is returned to the caller, who must
+// close its body. The request name is only used for logging and errors,
+// and each retry is added as an event to the span in ctx.
+func (p RetryPolicy) Do(ctx context.Context, request string, send func() (*http.Response, error)) (*http.Response, error) {
+	var lastErr error
+	var lastCode int
+	for attempt := 1; ; attempt++ {
+		resp, err := send()
+		var retryAfter time.Duration
+		if err != nil {
+			lastErr, lastCode = err, 0
+		} else if retryable(resp.StatusCode) {
+			lastErr, lastCode = nil, resp.StatusCode
+			retryAfter, _ = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			return resp, nil
+		}
+		if attempt >= p.MaxAttempts {
+			return nil, &RetryError{
+				Request:    request,
+				Attempts:   attempt,
+				StatusCode: lastCode,
+				Err:        lastErr,
+			}
+		}
+		wait := p.Backoff(attempt, retryAfter)
+		fields := log.Fields{
+			"request": request,
+			"attempt": attempt,
+			"retry":   wait.String(),
+		}
+		if lastErr != nil {
+			fields["error"] = lastErr
+		} else {
+			fields["code"] = lastCode
+		}
+		logging.From(ctx).WithFields(fields).Warn("Request failed, retrying")
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.Int("http.status_code", lastCode),
+			attribute.String("retry.wait", wait.String())))
+		sleep(wait)
+	}
+}
@@ -0,0 +1,88 @@
+package umapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, time.November, 5, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"30", time.Second * 30, true},
+		{" 5 ", time.Second * 5, true},
+		{"-1", 0, false},
+		{"Mon, 05 Nov 2018 12:00:45 GMT", time.Second * 45, true},
+		{"Mon, 05 Nov 2018 11:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %v, %v, wanted %v, %v\n", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Second * 30}
+	for attempt := 1; attempt < 10; attempt++ {
+		got := p.Backoff(attempt, 0)
+		if got <= 0 || got > p.MaxDelay {
+			t.Errorf("attempt %d: backoff %v outside (0, %v]\n", attempt, got, p.MaxDelay)
+		}
+	}
+	if got := p.Backoff(1, time.Second*10); got < time.Second*10 {
+		t.Errorf("backoff %v is shorter than Retry-After\n", got)
+	}
+	if got := p.Backoff(1, time.Hour); got != p.MaxDelay {
+		t.Errorf("backoff %v was not capped at %v\n", got, p.MaxDelay)
+	}
+}
+
+func TestDo(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+	p := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: time.Second * 30}
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "Mon, 05 Nov 2018 12:00:45 GMT")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer ts.Close()
+	resp, err := p.Do(context.Background(), "test", func() (*http.Response, error) {
+		return http.Get(ts.URL)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls != 3 {
+		t.Errorf("Do made %d requests, wanted 3\n", calls)
+	}
+
+	calls = 0
+	_, err = p.Do(context.Background(), "test", func() (*http.Response, error) {
+		calls++
+		return nil, &RetryError{}
+	})
+	if _, ok := err.(*RetryError); !ok || calls != p.MaxAttempts {
+		t.Errorf("Do returned %v after %d calls, wanted a RetryError after %d\n", err, calls, p.MaxAttempts)
+	}
+}
@@ -1,14 +1,16 @@
 package umapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/cosmouser/mudwork/config"
+	"github.com/cosmouser/mudwork/logging"
+	"github.com/cosmouser/mudwork/metrics"
+	"github.com/cosmouser/mudwork/tracing"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -36,125 +38,144 @@ func SendRequest(body string, token *AccessResponse) (*http.Response, error) {
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("x-api-key", config.C.Enterprise["APIKey"])
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	return send(httpClient, req)
+}
+
+// send paces req with Throttle and feeds the response's rate limit
+// headers back into it
+func send(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	Throttle.Wait()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	Throttle.Update(resp)
 	return resp, nil
 }
 
-// GetGroups returns the Groups from the configured Adobe endpoint
-func GetGroups(token *AccessResponse) (groups []Group, err error) {
-	var lastPage bool
-	var numRenews, retryAmount int
-	for i := 0; lastPage != true; i++ {
-		gR, err := getGroupPage(i, token)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-		}
-		switch gR.StatusCode {
-		case 200:
-			defer gR.Body.Close()
-			output, err := ioutil.ReadAll(gR.Body)
-			if err != nil {
-				return nil, err
-			}
-			gro := &GroupResponse{}
-			err = json.Unmarshal(output, gro)
+// StatusError is returned when an Adobe endpoint answers with a
+// response code that retrying will not fix
+type StatusError struct {
+	Request    string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s received response code %d: %s", e.Request, e.StatusCode, e.Message)
+}
+
+// get sends an authorized GET for path, relative to the configured
+// User Management API endpoint, and returns the body of a 200 response.
+// An expired token is renewed once before giving up.
+func get(request, path string, token *AccessResponse) ([]byte, error) {
+	var httpClient = &http.Client{
+		Timeout: time.Second * 10,
+	}
+	resourceURI := fmt.Sprintf("https://%s%s%s",
+		config.C.Server["Host"],
+		config.C.Server["Endpoint"],
+		path,
+	)
+	for renewed := false; ; renewed = true {
+		resp, err := Retry.Do(context.Background(), request, func() (*http.Response, error) {
+			req, err := http.NewRequest("GET", resourceURI, nil)
 			if err != nil {
 				return nil, err
 			}
-			for _, j := range gro.Groups {
-				groups = append(groups, j)
-			}
-			lastPage = gro.LastPage
+			req.Header.Add("Content-Type", "application/json")
+			req.Header.Add("Accept", "application/json")
+			req.Header.Add("x-api-key", config.C.Enterprise["APIKey"])
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+			return send(httpClient, req)
+		})
+		if err != nil {
+			return nil, err
+		}
+		output, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case 200:
+			return output, nil
 		case 400:
-			//			err = fmt.Errorf("GetGroups received response code 400. Some parameters of the request were not understood by the server or the Service Account Integration certificate has expired.")
-			//			return nil, err
-			log.WithFields(log.Fields{
-				"request": "GetGroups",
-				"code":    gR.StatusCode,
-				"page":    i,
-			}).Fatal("Bad request or Service Account Integration Certificate has expired.")
+			return nil, &StatusError{request, resp.StatusCode, "Bad request or Service Account Integration Certificate has expired."}
 		case 401:
 			log.WithFields(log.Fields{
-				"request": "GetGroups",
-				"code":    gR.StatusCode,
-				"page":    i,
+				"request": request,
+				"code":    resp.StatusCode,
 			}).Warn("Possible causes are invalid token, expired token or invalid organization.")
+			if renewed {
+				return nil, &StatusError{request, resp.StatusCode, "Token was rejected after renewal."}
+			}
 			token.Renew()
-			numRenews++
-			i--
 		case 403:
-			log.WithFields(log.Fields{
-				"request": "GetGroups",
-				"code":    gR.StatusCode,
-				"page":    i,
-			}).Fatal("Missing API key or API key is not permitted access.")
-		case 429:
-			retryAfterString := gR.Header.Get("Retry-After")
-			retryAfterInt, err := strconv.Atoi(retryAfterString)
-			if err != nil {
-				panic(err)
-			}
-			retryAmount += retryAfterInt + 1 // add an additional second for good measure
-			log.WithFields(log.Fields{
-				"request": "GetGroups",
-				"code":    gR.StatusCode,
-				"page":    i,
-				"retry":   retryAmount,
-			}).Warn("Too many requests")
-			time.Sleep(time.Duration(retryAmount) * time.Second)
-			i--
+			return nil, &StatusError{request, resp.StatusCode, "Missing API key or API key is not permitted access."}
+		case 404:
+			return nil, &StatusError{request, resp.StatusCode, "Not found."}
 		default:
-			log.WithFields(log.Fields{
-				"request": "GetGroups",
-				"code":    gR.StatusCode,
-				"page":    i,
-			}).Fatal("Unhandled response code. Mudwork config may be incorrect.")
+			return nil, &StatusError{request, resp.StatusCode, "Unhandled response code. Mudwork config may be incorrect."}
 		}
 	}
-	return groups, err
 }
 
-// getGroupPage returns a single page from the groups endpoint
-func getGroupPage(page int, token *AccessResponse) (*http.Response, error) {
-	var httpClient = &http.Client{
-		Timeout: time.Second * 10,
-	}
-	resourceURI := fmt.Sprintf("https://%s%s/groups/%s/%d",
-		config.C.Server["Host"],
-		config.C.Server["Endpoint"],
-		config.C.Enterprise["OrgID"],
-		page,
-	)
-	req, err := http.NewRequest("GET", resourceURI, nil)
-	if err != nil {
-		return nil, err
+// getPages walks a paginated endpoint from page 0, passing each page's
+// body to decode until decode reports the last page
+func getPages(request string, token *AccessResponse, path func(page int) string, decode func([]byte) (bool, error)) error {
+	for page := 0; ; page++ {
+		output, err := get(request, path(page), token)
+		if err != nil {
+			return err
+		}
+		lastPage, err := decode(output)
+		if err != nil {
+			return err
+		}
+		if lastPage {
+			return nil
+		}
 	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("x-api-key", config.C.Enterprise["APIKey"])
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
-	resp, err := httpClient.Do(req)
+}
+
+// GetGroups returns the Groups from the configured Adobe endpoint
+func GetGroups(token *AccessResponse) (groups []Group, err error) {
+	err = getPages("GetGroups", token, func(page int) string {
+		return fmt.Sprintf("/groups/%s/%d", config.C.Enterprise["OrgID"], page)
+	}, func(output []byte) (bool, error) {
+		gro := &GroupResponse{}
+		if err := json.Unmarshal(output, gro); err != nil {
+			return false, err
+		}
+		groups = append(groups, gro.Groups...)
+		return gro.LastPage, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return resp, nil
+	return groups, nil
 }
 
 // Renew renews the token
 func (token *AccessResponse) Renew() {
 	var err error
+	_, span := tracing.Start(context.Background(), "adobe.token")
+	defer func() { tracing.End(span, err) }()
 	log.Info("Renewing Token")
 	generated_jwt := GenerateJwt()
 	accessRequest := AccessRequestBody(generated_jwt)
 	newToken, err := RequestAccess(accessRequest)
 	if err != nil {
-		log.Printf("%s\n", err)
+		metrics.TokenRefreshFailures.WithLabelValues("adobe").Inc()
+		log.WithFields(log.Fields{
+			"request": "token",
+		}).Error(err)
+		return
 	}
 	token.TokenType = newToken.TokenType
 	token.AccessToken = newToken.AccessToken
+	logging.SetSecret("adobe.token", token.AccessToken)
 	token.ExpiresIn = newToken.ExpiresIn
 }
 
@@ -0,0 +1,98 @@
+package umapi
+
+import (
+	"encoding/json"
+	"github.com/cosmouser/mudwork/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGetPages(t *testing.T) {
+	type page struct {
+		code int
+		body string
+	}
+	tests := []struct {
+		name     string
+		pages    []page
+		requests int
+		users    []string
+		code     int  // StatusCode of the *StatusError wanted
+		fails    bool // a malformed body is wanted
+	}{
+		{"one page", []page{
+			{200, `{"lastPage": true, "result": "success", "users": [{"email": "jdoe@uni.edu"}]}`},
+		}, 1, []string{"jdoe@uni.edu"}, 0, false},
+		{"stops at lastPage", []page{
+			{200, `{"lastPage": false, "result": "success", "users": [{"email": "jdoe@uni.edu"}, {"email": "asmith@uni.edu"}]}`},
+			{200, `{"lastPage": false, "result": "success", "users": [{"email": "mroe@uni.edu"}]}`},
+			{200, `{"lastPage": true, "result": "success", "users": []}`},
+			{200, `{"lastPage": true, "result": "success", "users": [{"email": "never@uni.edu"}]}`},
+		}, 3, []string{"jdoe@uni.edu", "asmith@uni.edu", "mroe@uni.edu"}, 0, false},
+		{"forbidden", []page{
+			{403, `{"error_code": "403003", "message": "Api Key is invalid"}`},
+		}, 1, nil, 403, false},
+		{"not found on a later page", []page{
+			{200, `{"lastPage": false, "result": "success", "users": [{"email": "jdoe@uni.edu"}]}`},
+			{404, ``},
+		}, 2, nil, 404, false},
+		{"malformed", []page{
+			{200, `{"lastPage": false, "users": [`},
+		}, 1, nil, 0, true},
+	}
+	for _, tt := range tests {
+		requested := []string{}
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.Path)
+			if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("x-api-key") != "key" {
+				t.Errorf("%s: got headers %v\n", tt.name, r.Header)
+			}
+			for i, j := range tt.pages {
+				if r.URL.Path == "/v2/usermanagement/users/org/"+strconv.Itoa(i) {
+					w.WriteHeader(j.code)
+					w.Write([]byte(j.body))
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		saved, savedTransport := config.C, http.DefaultTransport
+		config.C.Server = map[string]string{"Host": strings.TrimPrefix(ts.URL, "https://"), "Endpoint": "/v2/usermanagement"}
+		config.C.Enterprise = map[string]string{"OrgID": "org", "APIKey": "key"}
+		// get uses the default transport, which doesn't trust ts
+		http.DefaultTransport = ts.Client().Transport
+
+		users, err := GetUsers(&AccessResponse{AccessToken: "token"})
+
+		config.C, http.DefaultTransport = saved, savedTransport
+		ts.Close()
+		emails := []string{}
+		for _, j := range users {
+			emails = append(emails, j.Email)
+		}
+		switch {
+		case tt.code != 0:
+			if se, ok := err.(*StatusError); !ok || se.StatusCode != tt.code || se.Request != "GetUsers" {
+				t.Errorf("%s: got %v, wanted a %d StatusError\n", tt.name, err, tt.code)
+			}
+		case tt.fails:
+			if _, ok := err.(*json.SyntaxError); !ok {
+				t.Errorf("%s: got %T %v, wanted a *json.SyntaxError\n", tt.name, err, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v\n", tt.name, err)
+		case !reflect.DeepEqual(emails, tt.users):
+			t.Errorf("%s: got %v, wanted %v\n", tt.name, emails, tt.users)
+		}
+		if err != nil && users != nil {
+			t.Errorf("%s: got %d users along with an error\n", tt.name, len(users))
+		}
+		if len(requested) != tt.requests {
+			t.Errorf("%s: requested %v, wanted pages 0 to %d\n", tt.name, requested, tt.requests-1)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package umapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cosmouser/mudwork/config"
+	"net/url"
+)
+
+// User is an account in the Adobe org as returned by the users endpoints
+type User struct {
+	ID        string   `json:"id"`
+	Email     string   `json:"email"`
+	Status    string   `json:"status"`
+	Groups    []string `json:"groups,omitempty"`
+	Username  string   `json:"username"`
+	Domain    string   `json:"domain"`
+	FirstName string   `json:"firstname"`
+	LastName  string   `json:"lastname"`
+	Country   string   `json:"country"`
+	Type      string   `json:"type"`
+}
+
+// UsersResponse is a single page from the users endpoints
+type UsersResponse struct {
+	LastPage bool   `json:"lastPage"`
+	Result   string `json:"result"`
+	Users    []User `json:"users"`
+}
+
+// UserResponse is the body returned when looking up a single user
+type UserResponse struct {
+	Result string `json:"result"`
+	User   User   `json:"user"`
+}
+
+// InGroup reports whether the user is a member of group
+func (u *User) InGroup(group string) bool {
+	for _, j := range u.Groups {
+		if j == group {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUsers returns every user in the org
+func GetUsers(token *AccessResponse) ([]User, error) {
+	return getUserPages("GetUsers", token, func(page int) string {
+		return fmt.Sprintf("/users/%s/%d", config.C.Enterprise["OrgID"], page)
+	})
+}
+
+// GetUsersInGroup returns the members of a user group or product profile
+func GetUsersInGroup(token *AccessResponse, group string) ([]User, error) {
+	return getUserPages("GetUsersInGroup", token, func(page int) string {
+		return fmt.Sprintf("/users/%s/%d/%s", config.C.Enterprise["OrgID"], page, url.PathEscape(group))
+	})
+}
+
+// GetUser looks up a single user by email or username. A user that
+// isn't in the org is reported as a *StatusError with StatusCode 404.
+func GetUser(token *AccessResponse, userString string) (*User, error) {
+	output, err := get("GetUser", fmt.Sprintf("/organizations/%s/users/%s",
+		config.C.Enterprise["OrgID"],
+		url.PathEscape(userString),
+	), token)
+	if err != nil {
+		return nil, err
+	}
+	ur := &UserResponse{}
+	err = json.Unmarshal(output, ur)
+	if err != nil {
+		return nil, err
+	}
+	return &ur.User, nil
+}
+
+func getUserPages(request string, token *AccessResponse, path func(page int) string) (users []User, err error) {
+	err = getPages(request, token, path, func(output []byte) (bool, error) {
+		ur := &UsersResponse{}
+		if err := json.Unmarshal(output, ur); err != nil {
+			return false, err
+		}
+		users = append(users, ur.Users...)
+		return ur.LastPage, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}